@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+type Role int
+
+const (
+	Viewer Role = iota + 1
+	Deployer
+	Admin
+)
+
+func (r Role) String() string {
+	switch r {
+	case Viewer:
+		return "viewer"
+	case Deployer:
+		return "deployer"
+	case Admin:
+		return "admin"
+	}
+	return "none"
+}
+
+type User struct {
+	Login string `json:"login"`
+	Role  Role   `json:"role"`
+}
+
+type Authenticator interface {
+	Authenticate(r *http.Request) (User, bool)
+}
+
+type Tokens []string
+
+func (t Tokens) Authenticate(r *http.Request) (User, bool) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return User{}, false
+	}
+	token := []byte(strings.TrimPrefix(header, "Bearer "))
+
+	for _, valid := range t {
+		if valid != "" && subtle.ConstantTimeCompare(token, []byte(valid)) == 1 {
+			return User{Login: "api-token", Role: Admin}, true
+		}
+	}
+
+	return User{}, false
+}
+
+type Any []Authenticator
+
+func (a Any) Authenticate(r *http.Request) (User, bool) {
+	for _, authenticator := range a {
+		if authenticator == nil {
+			continue
+		}
+		if user, ok := authenticator.Authenticate(r); ok {
+			return user, true
+		}
+	}
+
+	return User{}, false
+}
+
+func Require(a Authenticator, role Role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a == nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		user, ok := a.Authenticate(r)
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if user.Role < role {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/google/go-github/github"
+	"golang.org/x/oauth2"
+	githuboauth "golang.org/x/oauth2/github"
+)
+
+var ErrNotMember = errors.New("user is not a member of the organization")
+
+type GitHub struct {
+	OAuth         *oauth2.Config
+	Organization  string
+	ViewerTeams   []string
+	DeployerTeams []string
+	AdminTeams    []string
+	BaseURL       string
+}
+
+func NewGitHub(clientId, clientSecret, redirectUrl, organization string) *GitHub {
+	return &GitHub{
+		OAuth: &oauth2.Config{
+			ClientID:     clientId,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectUrl,
+			Endpoint:     githuboauth.Endpoint,
+			Scopes:       []string{"read:org"},
+		},
+		Organization: organization,
+	}
+}
+
+func (g *GitHub) AuthCodeURL(state string) string {
+	return g.OAuth.AuthCodeURL(state)
+}
+
+func (g *GitHub) Login(ctx context.Context, code string) (User, error) {
+	token, err := g.OAuth.Exchange(ctx, code)
+	if err != nil {
+		return User{}, err
+	}
+
+	client, err := g.client(ctx, token)
+	if err != nil {
+		return User{}, err
+	}
+
+	githubUser, _, err := client.Users.Get(ctx, "")
+	if err != nil {
+		return User{}, err
+	}
+
+	membership, resp, err := client.Organizations.GetOrgMembership(ctx, "", g.Organization)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return User{}, ErrNotMember
+	}
+	if err != nil {
+		return User{}, err
+	}
+	if membership.GetState() != "active" {
+		return User{}, ErrNotMember
+	}
+
+	user := User{Login: githubUser.GetLogin()}
+	if membership.GetRole() == "admin" {
+		user.Role = Admin
+		return user, nil
+	}
+
+	teams, err := g.listTeams(ctx, client)
+	if err != nil {
+		return User{}, err
+	}
+
+	switch {
+	case containsAny(teams, g.AdminTeams):
+		user.Role = Admin
+	case containsAny(teams, g.DeployerTeams):
+		user.Role = Deployer
+	case len(g.ViewerTeams) == 0 || containsAny(teams, g.ViewerTeams):
+		user.Role = Viewer
+	default:
+		return User{}, ErrNotMember
+	}
+
+	return user, nil
+}
+
+func (g *GitHub) client(ctx context.Context, token *oauth2.Token) (*github.Client, error) {
+	client := github.NewClient(g.OAuth.Client(ctx, token))
+	if g.BaseURL != "" {
+		baseURL, err := url.Parse(g.BaseURL)
+		if err != nil {
+			return nil, err
+		}
+		client.BaseURL = baseURL
+	}
+	return client, nil
+}
+
+func (g *GitHub) listTeams(ctx context.Context, client *github.Client) ([]string, error) {
+	var slugs []string
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		teams, resp, err := client.Teams.ListUserTeams(ctx, opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, team := range teams {
+			if team.GetOrganization().GetLogin() == g.Organization {
+				slugs = append(slugs, team.GetSlug())
+			}
+		}
+		if resp.NextPage == 0 {
+			return slugs, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+func containsAny(values []string, wanted []string) bool {
+	for _, value := range values {
+		for _, w := range wanted {
+			if value == w {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+type fakeGitHubUser struct {
+	login   string
+	state   string
+	orgRole string
+	teams   []string
+}
+
+func newFakeGitHub(user fakeGitHubUser) *httptest.Server {
+	router := http.NewServeMux()
+	router.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "valid-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"user-token","token_type":"bearer"}`)
+	})
+	router.HandleFunc("/api/user", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"login":%q}`, user.login)
+	})
+	router.HandleFunc("/api/user/memberships/orgs/vektorprogrammet", func(w http.ResponseWriter, r *http.Request) {
+		if user.state == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if user.state == "outage" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		fmt.Fprintf(w, `{"state":%q,"role":%q}`, user.state, user.orgRole)
+	})
+	router.HandleFunc("/api/user/teams", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"slug":"admins","organization":{"login":"another-org"}}`)
+		for _, team := range user.teams {
+			fmt.Fprintf(w, `,{"slug":%q,"organization":{"login":"vektorprogrammet"}}`, team)
+		}
+		fmt.Fprint(w, `]`)
+	})
+
+	return httptest.NewServer(router)
+}
+
+func newTestGitHub(server *httptest.Server) *GitHub {
+	g := NewGitHub("client-id", "client-secret", "https://staging.example.com/auth/callback", "vektorprogrammet")
+	g.OAuth.Endpoint = oauth2.Endpoint{
+		AuthURL:  server.URL + "/login/oauth/authorize",
+		TokenURL: server.URL + "/login/oauth/access_token",
+	}
+	g.BaseURL = server.URL + "/api/"
+	g.DeployerTeams = []string{"developers"}
+	g.AdminTeams = []string{"admins"}
+	return g
+}
+
+func TestGitHub_Login(t *testing.T) {
+	tests := []struct {
+		user         fakeGitHubUser
+		expectedRole Role
+		expectedErr  bool
+	}{
+		{fakeGitHubUser{login: "viewer", state: "active", orgRole: "member"}, Viewer, false},
+		{fakeGitHubUser{login: "dev", state: "active", orgRole: "member", teams: []string{"developers"}}, Deployer, false},
+		{fakeGitHubUser{login: "lead", state: "active", orgRole: "member", teams: []string{"developers", "admins"}}, Admin, false},
+		{fakeGitHubUser{login: "owner", state: "active", orgRole: "admin"}, Admin, false},
+		{fakeGitHubUser{login: "invited", state: "pending", orgRole: "member"}, 0, true},
+		{fakeGitHubUser{login: "outsider"}, 0, true},
+	}
+
+	for _, test := range tests {
+		server := newFakeGitHub(test.user)
+		user, err := newTestGitHub(server).Login(context.Background(), "valid-code")
+		server.Close()
+
+		if test.expectedErr {
+			if err != ErrNotMember {
+				t.Errorf("%s: Expected ErrNotMember, got %v", test.user.login, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Unexpected error %s", test.user.login, err)
+			continue
+		}
+		if user.Login != test.user.login || user.Role != test.expectedRole {
+			t.Errorf("%s: Expected role %s, got %+v", test.user.login, test.expectedRole, user)
+		}
+	}
+}
+
+func TestGitHub_LoginWithInvalidCode(t *testing.T) {
+	server := newFakeGitHub(fakeGitHubUser{login: "dev", state: "active"})
+	defer server.Close()
+
+	if _, err := newTestGitHub(server).Login(context.Background(), "invalid-code"); err == nil {
+		t.Error("Expected login with invalid code to fail")
+	}
+}
+
+func TestGitHub_LoginDuringOutage(t *testing.T) {
+	server := newFakeGitHub(fakeGitHubUser{login: "dev", state: "outage"})
+	defer server.Close()
+
+	_, err := newTestGitHub(server).Login(context.Background(), "valid-code")
+	if err == nil || err == ErrNotMember {
+		t.Errorf("Expected a GitHub error, got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const sessionCookieName = "staging_session"
+
+// CSRFHeader must be set on requests that change something with a session.
+// Cross-site forms can not set headers, and cross-origin scripts can only set
+// it from the origins that CORS allows.
+const CSRFHeader = "X-Requested-With"
+
+type Sessions struct {
+	Key    []byte
+	MaxAge time.Duration
+}
+
+type session struct {
+	User    User  `json:"user"`
+	Expires int64 `json:"expires"`
+}
+
+func NewSessions(key []byte) *Sessions {
+	return &Sessions{
+		Key:    key,
+		MaxAge: 7 * 24 * time.Hour,
+	}
+}
+
+func (s *Sessions) Set(w http.ResponseWriter, user User) error {
+	data, err := json.Marshal(session{
+		User:    user,
+		Expires: time.Now().Add(s.MaxAge).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    payload + "." + s.sign(payload),
+		Path:     "/",
+		MaxAge:   int(s.MaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+func (s *Sessions) Clear(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (s *Sessions) Authenticate(r *http.Request) (User, bool) {
+	if len(s.Key) == 0 {
+		return User{}, false
+	}
+	if !safeMethod(r.Method) && r.Header.Get(CSRFHeader) == "" {
+		return User{}, false
+	}
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return User{}, false
+	}
+
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(s.sign(parts[0]))) {
+		return User{}, false
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return User{}, false
+	}
+	var sess session
+	if err := json.Unmarshal(data, &sess); err != nil {
+		return User{}, false
+	}
+	if time.Now().Unix() > sess.Expires {
+		return User{}, false
+	}
+
+	return sess.User, true
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func (s *Sessions) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.Key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSessions_Authenticate(t *testing.T) {
+	sessions := NewSessions([]byte("session-key"))
+
+	w := httptest.NewRecorder()
+	if err := sessions.Set(w, User{Login: "dev", Role: Deployer}); err != nil {
+		t.Fatal(err)
+	}
+	cookie := w.Result().Cookies()[0]
+
+	r := httptest.NewRequest("GET", "/api/servers", nil)
+	r.AddCookie(cookie)
+	user, ok := sessions.Authenticate(r)
+	if !ok || user.Login != "dev" || user.Role != Deployer {
+		t.Errorf("Expected dev deployer session, got %+v (%t)", user, ok)
+	}
+
+	r = httptest.NewRequest("GET", "/api/servers", nil)
+	cookie.Value = cookie.Value[:len(cookie.Value)-1] + "x"
+	r.AddCookie(cookie)
+	if _, ok := sessions.Authenticate(r); ok {
+		t.Error("Expected tampered session to be rejected")
+	}
+
+	other := NewSessions([]byte("another-key"))
+	w = httptest.NewRecorder()
+	other.Set(w, User{Login: "dev", Role: Admin})
+	r = httptest.NewRequest("GET", "/api/servers", nil)
+	r.AddCookie(w.Result().Cookies()[0])
+	if _, ok := sessions.Authenticate(r); ok {
+		t.Error("Expected session signed with another key to be rejected")
+	}
+
+	expired := NewSessions([]byte("session-key"))
+	expired.MaxAge = -time.Minute
+	w = httptest.NewRecorder()
+	expired.Set(w, User{Login: "dev", Role: Admin})
+	r = httptest.NewRequest("GET", "/api/servers", nil)
+	cookie = w.Result().Cookies()[0]
+	r.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+	if _, ok := sessions.Authenticate(r); ok {
+		t.Error("Expected expired session to be rejected")
+	}
+}
+
+func TestSessions_RequireCSRFHeaderForWrites(t *testing.T) {
+	sessions := NewSessions([]byte("session-key"))
+	w := httptest.NewRecorder()
+	sessions.Set(w, User{Login: "dev", Role: Deployer})
+	cookie := w.Result().Cookies()[0]
+	if cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("Expected a SameSite=Lax cookie, got %v", cookie.SameSite)
+	}
+
+	r := httptest.NewRequest("POST", "/api/servers/feature/redeploy", nil)
+	r.AddCookie(cookie)
+	if _, ok := sessions.Authenticate(r); ok {
+		t.Error("Expected a write without the CSRF header to be rejected")
+	}
+
+	r.Header.Set(CSRFHeader, "XMLHttpRequest")
+	if _, ok := sessions.Authenticate(r); !ok {
+		t.Error("Expected a write with the CSRF header to be accepted")
+	}
+}
@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultApiUrl = "http://localhost:5555"
+
+var apiClient = &http.Client{Timeout: 30 * time.Second}
+
+// apiUrl is STAGING_API_URL, the address of the running staging server.
+func apiUrl() string {
+	url := os.Getenv("STAGING_API_URL")
+	if url == "" {
+		url = defaultApiUrl
+	}
+	return strings.TrimRight(url, "/")
+}
+
+// apiToken is STAGING_API_TOKEN, or the first of the API_TOKENS the server is
+// started with.
+func apiToken() string {
+	if token := os.Getenv("STAGING_API_TOKEN"); token != "" {
+		return token
+	}
+	return strings.TrimSpace(strings.Split(os.Getenv("API_TOKENS"), ",")[0])
+}
+
+// post sends body as JSON to the API of the running staging server. The
+// response is closed, and its body is returned for error messages.
+func post(apiUrl, token, path string, body interface{}) (status int, message string, err error) {
+	if token == "" {
+		return 0, "", errors.New("no API token, set STAGING_API_TOKEN or API_TOKENS")
+	}
+
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return 0, "", err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest("POST", apiUrl+path, reader)
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	response, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<10))
+	return resp.StatusCode, strings.TrimSpace(string(response)), nil
+}
+
+// unexpected is the error for a response of the staging server that is not
+// handled by the command.
+func unexpected(status int, message string) error {
+	if message == "" {
+		return fmt.Errorf("staging server responded with %d %s", status, http.StatusText(status))
+	}
+	return fmt.Errorf("staging server responded with %d %s: %s", status, http.StatusText(status), message)
+}
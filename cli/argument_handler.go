@@ -2,6 +2,7 @@ package cli
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
 )
 
@@ -13,8 +14,14 @@ func HandleArguments() (keepRunning bool) {
 				println(err)
 			}
 			return false
+		} else if len(os.Args) > 4 && os.Args[2] == "--ref" {
+			err := DeployBranch(os.Args[4], os.Args[3])
+			if err != nil {
+				println(err)
+			}
+			return false
 		} else {
-			err := DeployBranch(os.Args[2])
+			err := DeployBranch(os.Args[2], "")
 			if err != nil {
 				println(err)
 			}
@@ -22,12 +29,38 @@ func HandleArguments() (keepRunning bool) {
 		}
 	}
 
-	if len(os.Args) == 2 && (os.Args[1] == "list-servers" || os.Args[1] == "ls") {
-		servers := ListServers()
-		for i := 0; i < len(servers); i++ {
-			fmt.Printf("%s ", servers[i].Branch)
+	if len(os.Args) == 3 && os.Args[1] == "replay" {
+		err := ReplayDelivery(os.Args[2])
+		if err != nil {
+			fmt.Printf("Could not replay delivery %s: %s\n", os.Args[2], err)
+		}
+		return false
+	}
+
+	if len(os.Args) > 2 && os.Args[1] == "rollback" {
+		args := os.Args[2:]
+		restoreDatabase := args[0] == "--restore-db"
+		if restoreDatabase {
+			args = args[1:]
 		}
-		fmt.Printf("\n")
+		if len(args) == 0 || len(args) > 2 {
+			fmt.Println("Usage: rollback [--restore-db] <branch> [release]")
+			return false
+		}
+		release := ""
+		if len(args) == 2 {
+			release = args[1]
+		}
+		err := RollbackServer(args[0], release, restoreDatabase)
+		if err != nil {
+			fmt.Printf("Could not roll back %s: %s\n", args[0], err)
+		}
+		return false
+	}
+
+	if len(os.Args) == 2 && (os.Args[1] == "list-servers" || os.Args[1] == "ls") {
+		PrintServers(os.Stdout, ListServers(slog.Default()))
+		return false
 	}
 
 	if len(os.Args) > 1 {
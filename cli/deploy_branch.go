@@ -4,62 +4,61 @@ import (
 	"context"
 	"fmt"
 	"github.com/google/go-github/github"
-	"github.com/vektorprogrammet/build-system/staging"
+	"github.com/vektorprogrammet/build-system/githubclient"
 	"github.com/vektorprogrammet/build-system/messenger"
-	"os"
+	"github.com/vektorprogrammet/build-system/staging"
+	"net/http"
 )
 
-func DeployBranch(branchName string) error {
-	ctx := context.Background()
-	client := github.NewClient(nil)
-	slack := messenger.NewSlack(os.Getenv("SLACK_ENDPOINT"), "#staging_log", "vektorbot", ":robot_face:")
+// DeployBranch asks the running staging server to deploy the head of a
+// branch, or the commit, tag or branch in ref. Servers deployed from a ref are
+// pinned so new commits do not move them until the branch is deployed without
+// a ref.
+func DeployBranch(branchName, ref string) error {
+	return deploy(apiUrl(), apiToken(), branchName, ref)
+}
 
-	if err := EnsureBranchExists(ctx, client, branchName); err != nil {
+func deploy(apiUrl, token, branchName, ref string) error {
+	body := map[string]string{"branch": branchName}
+	if ref != "" {
+		body["ref"] = ref
+	}
+	status, message, err := post(apiUrl, token, "/api/servers", body)
+	if err != nil {
 		return err
 	}
-
-	server := staging.NewServer(branchName, func(message string, progress int) {
-		fmt.Printf("%s %d\n", message, progress)
-		slack.Send(fmt.Sprintf("%s: %s %d %%", branchName, message, progress))
-	})
-
-	if server.Exists() {
-		fmt.Println("Server exists. Forcing update...")
-		slack.Send(fmt.Sprintf("%s: %s", branchName, "Server exists. Forcing update..."))
-		if server.CanBeFastForwarded() {
-			server.Update()
-			fmt.Println("Server updated.")
-			slack.Send(fmt.Sprintf("%s: %s", branchName, "Server updated."))
-		} else {
-			fmt.Println("Did not update: Branch is up to date with origin/master")
-			slack.Send(fmt.Sprintf("%s: %s", branchName, "Did not update: Branch is up to date with origin/master"))
-		}
-
-	} else {
-		err := server.Deploy()
-		if err != nil {
-			server.Remove()
-			fmt.Printf("Could not create staging server: %s\n", err)
-			slack.Send(fmt.Sprintf("%s: Could not create staging server: %s", branchName, err))
-		} else {
-			fmt.Printf("Staging server deployed at https://%s\n", server.ServerName())
-			slack.Send(fmt.Sprintf("%s: Staging server deployed at https://%s", branchName, server.ServerName()))
-		}
+	if status != http.StatusAccepted {
+		return unexpected(status, message)
 	}
 
+	fmt.Printf("Deploying %s in the queue of the staging server\n", branchName)
+	if ref != "" {
+		fmt.Printf("%s is pinned to %s once it is deployed. Run deploy-branch without --ref to follow the branch again\n", branchName, ref)
+	}
 	return nil
 }
 
 func StopServer(branchName string) error {
 	ctx := context.Background()
-	client := github.NewClient(nil)
+	clients, err := githubclient.FromEnv()
+	if err != nil {
+		return err
+	}
+	client, err := clients.Client(ctx, githubclient.DefaultOwner, githubclient.DefaultRepo)
+	if err != nil {
+		return err
+	}
 
 	if err := EnsureBranchExists(ctx, client, branchName); err != nil {
 		return err
 	}
 
+	if err := useTemplates(); err != nil {
+		return err
+	}
+	console := messenger.Track(messenger.NewConsole(), messenger.Deployment{Branch: branchName})
 	server := staging.NewServer(branchName, func(message string, progress int) {
-		fmt.Printf("%s %d\n", message, progress)
+		console.Progress(ctx, message, progress)
 	})
 
 	if server.Exists() {
@@ -76,8 +75,17 @@ func StopServer(branchName string) error {
 	return nil
 }
 
+func useTemplates() error {
+	templates, err := messenger.LoadTemplates(staging.DefaultInstallationFolder + "/templates")
+	if err != nil {
+		return err
+	}
+	messenger.UseTemplates(templates)
+	return nil
+}
+
 func EnsureBranchExists(ctx context.Context, client *github.Client, branchName string) error {
-	_, _, err := client.Git.GetRef(ctx, "vektorprogrammet", "vektorprogrammet", "refs/heads/"+branchName)
+	_, _, err := client.Git.GetRef(ctx, githubclient.DefaultOwner, githubclient.DefaultRepo, "refs/heads/"+branchName)
 	if err != nil {
 		fmt.Printf("Could not find branch %s: %s\n", branchName, err)
 		return err
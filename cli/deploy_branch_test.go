@@ -2,8 +2,12 @@ package cli
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/google/go-github/github"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -21,3 +25,31 @@ func TestEnsureBranchExists(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestDeploy(t *testing.T) {
+	var body map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/api/servers" || r.Header.Get("Authorization") != "Bearer secret-token" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body = nil
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["branch"] == "missing" {
+			http.Error(w, "Unknown branch", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	if err := deploy(server.URL, "secret-token", "feature", "v1.2"); err != nil {
+		t.Errorf("Expected the branch to be deployed, got %s", err)
+	}
+	if body["branch"] != "feature" || body["ref"] != "v1.2" {
+		t.Errorf("Expected the branch and ref to be sent, got %v", body)
+	}
+	if err := deploy(server.URL, "secret-token", "missing", ""); err == nil || !strings.Contains(err.Error(), "Unknown branch") {
+		t.Errorf("Expected the error of the staging server, got %v", err)
+	}
+}
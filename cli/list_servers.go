@@ -2,15 +2,21 @@ package cli
 
 import (
 	"fmt"
+	"github.com/vektorprogrammet/build-system/health"
 	"github.com/vektorprogrammet/build-system/staging"
+	"io"
+	"log/slog"
 	"os/exec"
 	"strings"
+	"text/tabwriter"
 )
 
-func ListServers() []staging.Server {
+// ListServers returns the deployed servers. Folders that can not be listed
+// are reported to logger.
+func ListServers(logger *slog.Logger) []staging.Server {
 	dirs, err := ListDirContents(staging.DefaultRootFolder)
 	if err != nil {
-		fmt.Println(err)
+		logger.Error("Could not list servers", "folder", staging.DefaultRootFolder, "error", err)
 		return nil
 	}
 	var servers []staging.Server
@@ -23,6 +29,28 @@ func ListServers() []staging.Server {
 	return servers
 }
 
+// PrintServers prints a table of servers with their health from the uptime
+// monitor.
+func PrintServers(out io.Writer, servers []staging.Server) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "BRANCH\tHEALTH\tUPTIME\tLATENCY")
+	for _, server := range servers {
+		status, uptime, latency := "unknown", "-", "-"
+		if report, _ := health.LoadReport(server.HealthFile()); report != nil {
+			status = "down"
+			if report.Healthy {
+				status = "up"
+				latency = fmt.Sprintf("%dms", report.LatencyMs())
+			}
+		}
+		if history, _ := health.LoadHistory(server.HealthHistoryFile()); len(history) > 0 {
+			uptime = fmt.Sprintf("%.1f%%", health.Uptime(history))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", server.Branch, status, uptime, latency)
+	}
+	w.Flush()
+}
+
 func ListDirContents(dir string) ([]string, error) {
 	c := exec.Command("sh", "-c", "ls")
 	c.Dir = dir
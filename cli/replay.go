@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// ReplayDelivery asks the running staging server to handle a stored GitHub
+// webhook delivery again. The jobs run in its queue, like the jobs of new
+// deliveries.
+func ReplayDelivery(id string) error {
+	return replay(apiUrl(), apiToken(), id)
+}
+
+func replay(apiUrl, token, id string) error {
+	status, message, err := post(apiUrl, token, "/api/deliveries/"+id+"/replay", nil)
+	if err != nil {
+		return err
+	}
+
+	switch status {
+	case http.StatusAccepted:
+		fmt.Printf("Replaying delivery %s\n", id)
+		return nil
+	case http.StatusNotFound:
+		return fmt.Errorf("no delivery with id %s", id)
+	}
+	return unexpected(status, message)
+}
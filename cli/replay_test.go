@@ -0,0 +1,38 @@
+package cli
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	var path, authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		authorization = r.Header.Get("Authorization")
+		if r.Method != "POST" || r.URL.Path != "/api/deliveries/abc/replay" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	if err := replay(server.URL, "secret-token", "abc"); err != nil {
+		t.Errorf("Expected the delivery to be replayed, got %s", err)
+	}
+	if path != "/api/deliveries/abc/replay" {
+		t.Errorf("Expected the replay endpoint to be called, got %s", path)
+	}
+	if authorization != "Bearer secret-token" {
+		t.Errorf("Expected the API token to be sent, got %q", authorization)
+	}
+
+	if err := replay(server.URL, "secret-token", "unknown"); err == nil {
+		t.Errorf("Expected an error for an unknown delivery")
+	}
+	if err := replay(server.URL, "", "abc"); err == nil {
+		t.Errorf("Expected an error without an API token")
+	}
+}
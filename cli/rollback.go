@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// RollbackServer asks the running staging server to switch a server back to
+// an earlier release, by default the one before the current release.
+func RollbackServer(branchName, release string, restoreDatabase bool) error {
+	return rollback(apiUrl(), apiToken(), branchName, release, restoreDatabase)
+}
+
+func rollback(apiUrl, token, branchName, release string, restoreDatabase bool) error {
+	body := map[string]interface{}{"release": release, "restore_database": restoreDatabase}
+	status, message, err := post(apiUrl, token, "/api/servers/"+branchName+"/rollback", body)
+	if err != nil {
+		return err
+	}
+
+	switch status {
+	case http.StatusAccepted:
+		fmt.Printf("Rolling back %s in the queue of the staging server\n", branchName)
+		return nil
+	case http.StatusNotFound:
+		return fmt.Errorf("no staging server deployed for branch %s", branchName)
+	}
+	return unexpected(status, message)
+}
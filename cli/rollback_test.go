@@ -0,0 +1,36 @@
+package cli
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRollback(t *testing.T) {
+	var path string
+	var body struct {
+		Release         string `json:"release"`
+		RestoreDatabase bool   `json:"restore_database"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		if r.Method != "POST" || r.URL.Path != "/api/servers/feature/login/rollback" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	if err := rollback(server.URL, "secret-token", "feature/login", "abc", true); err != nil {
+		t.Errorf("Expected the server to be rolled back, got %s", err)
+	}
+	if path != "/api/servers/feature/login/rollback" || body.Release != "abc" || !body.RestoreDatabase {
+		t.Errorf("Expected the release to be sent to the rollback endpoint, got %s with %+v", path, body)
+	}
+	if err := rollback(server.URL, "secret-token", "unknown", "", false); err == nil {
+		t.Errorf("Expected an error for a branch without a server")
+	}
+}
@@ -0,0 +1,260 @@
+package deployment
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/vektorprogrammet/build-system/git"
+	"github.com/vektorprogrammet/build-system/githubclient"
+	"github.com/vektorprogrammet/build-system/health"
+	"github.com/vektorprogrammet/build-system/logging"
+	"github.com/vektorprogrammet/build-system/messenger"
+	"github.com/vektorprogrammet/build-system/staging"
+	"github.com/vektorprogrammet/build-system/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type Action string
+
+const (
+	Deploy        Action = "deploy"
+	Update        Action = "update"
+	Redeploy      Action = "redeploy"
+	Remove        Action = "remove"
+	ResetDatabase Action = "reset-db"
+	Rollback      Action = "rollback"
+)
+
+const (
+	TriggerPush        = "push"
+	TriggerPullRequest = "pull_request"
+	TriggerCreate      = "create"
+	TriggerDelete      = "delete"
+	TriggerApi         = "api"
+	TriggerCli         = "cli"
+	TriggerChatOps     = "chatops"
+	TriggerSlack       = "slack"
+)
+
+type Job struct {
+	Action   Action
+	Branch   string
+	Sha      string
+	Ref      string
+	Trigger  string
+	PrNumber int
+	// Release to roll back to. The release before the current one is used
+	// when it is empty.
+	Release         string
+	RestoreDatabase bool
+	// Pin keeps the server at Sha once it is deployed, so new commits do not
+	// move it.
+	Pin bool
+	// Id correlates the log lines of a job. It is set when the job runs.
+	Id string
+	// DeliveryId is the GitHub webhook delivery that triggered the job.
+	DeliveryId string
+	// Trace is the span that enqueued the job. The job is traced as its child.
+	Trace trace.SpanContext
+	// Queued is when the job was enqueued, and is set by Enqueue.
+	Queued time.Time
+}
+
+func (j Job) automatic() bool {
+	return j.Trigger == TriggerPush || j.Trigger == TriggerPullRequest || j.Trigger == TriggerCreate
+}
+
+type Queue interface {
+	Enqueue(job Job)
+}
+
+// Server is what jobs do to a staging server. It is a *staging.Server unless
+// the engine is given other servers with NewServer.
+type Server interface {
+	Exists() bool
+	IsPinned() bool
+	Pin() error
+	ServerName() string
+	CurrentRef() string
+	LogFile() string
+	HealthFile() string
+	Deploy() error
+	Changes() (git.Comparison, error)
+	Update(c git.Comparison) error
+	Rollback(release string, restoreDatabase bool) (string, error)
+	ResetDatabase() error
+	Remove() error
+}
+
+type Engine struct {
+	Messenger messenger.Messenger
+	Github    githubclient.Factory
+	PublicUrl string
+	jobs      chan Job
+	deployed  map[string]string
+	mu        sync.Mutex
+	running   string
+
+	// HealthCheck runs after deploys and updates when it is set.
+	HealthCheck *health.Check
+	// Logger defaults to slog.Default.
+	Logger *slog.Logger
+	// NewServer returns the server of a branch. It defaults to a staging
+	// server in the default folders.
+	NewServer func(branch string) Server
+}
+
+func NewEngine(m messenger.Messenger, github githubclient.Factory) *Engine {
+	return &Engine{
+		Messenger: m,
+		Github:    github,
+		jobs:      make(chan Job, 100),
+		deployed:  map[string]string{},
+	}
+}
+
+func (e *Engine) Start() {
+	go func() {
+		for job := range e.jobs {
+			if e.alreadyDeployed(job) {
+				e.jobLogger(job).Info("Skipping job, the commit is already deployed", "sha", job.Sha)
+				continue
+			}
+
+			e.setRunning(e.server(job.Branch).ServerName())
+			err := e.Run(job)
+			e.setRunning("")
+			e.record(job, err)
+		}
+	}()
+}
+
+func (e *Engine) Enqueue(job Job) {
+	job.Queued = time.Now()
+	e.jobs <- job
+}
+
+// Busy is true while a job from the queue runs on the server with the name.
+// Servers are compared by name, since servers listed from their folders do not
+// know the branch they were deployed from.
+func (e *Engine) Busy(serverName string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return serverName != "" && e.running == serverName
+}
+
+func (e *Engine) setRunning(serverName string) {
+	e.mu.Lock()
+	e.running = serverName
+	e.mu.Unlock()
+}
+
+// QueueDepth is the number of jobs waiting to run.
+func (e *Engine) QueueDepth() int {
+	return len(e.jobs)
+}
+
+var deployments = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "staging_deployments_total",
+	Help: "Jobs run by the deployment engine.",
+}, []string{"action", "result", "trigger"})
+
+func (e *Engine) Run(job Job) error {
+	if job.Id == "" {
+		job.Id = logging.NewId()
+	}
+	ctx := tracing.Continue(job.Trace)
+	if !job.Queued.IsZero() {
+		_, queued := tracing.Start(ctx, "queued", trace.WithTimestamp(job.Queued))
+		queued.End()
+	}
+	ctx, span := tracing.Start(ctx, string(job.Action), trace.WithAttributes(
+		attribute.String("deployment.id", job.Id),
+		attribute.String("branch", job.Branch),
+		attribute.String("sha", job.Sha),
+		attribute.String("trigger", job.Trigger),
+		attribute.String("delivery.id", job.DeliveryId),
+		attribute.Int("pull_request", job.PrNumber),
+	))
+	x := newExecution(ctx, e, job)
+	x.logger.Info("Running job")
+
+	err := x.run()
+	tracing.End(span, err)
+	result := "success"
+	if err != nil {
+		result = "failure"
+		x.logger.Error("Job failed", "error", err)
+	} else {
+		x.logger.Info("Job finished")
+	}
+	deployments.WithLabelValues(string(job.Action), result, job.Trigger).Inc()
+	if job.Trigger == TriggerChatOps && x.commenter != nil {
+		x.reply(err)
+	}
+	return err
+}
+
+// alreadyDeployed coalesces automatic jobs for the same commit, like a push
+// and the synchronize event of its pull request.
+func (e *Engine) alreadyDeployed(job Job) bool {
+	if !job.automatic() || job.Sha == "" || e.deployed[job.Branch] != job.Sha {
+		return false
+	}
+	return e.server(job.Branch).Exists()
+}
+
+func (e *Engine) record(job Job, err error) {
+	switch {
+	case job.Action == Remove:
+		delete(e.deployed, job.Branch)
+	case err != nil || job.Action == ResetDatabase:
+		return
+	case job.Action == Rollback:
+		delete(e.deployed, job.Branch)
+	case job.Sha != "" && !e.server(job.Branch).IsPinned():
+		e.deployed[job.Branch] = job.Sha
+	}
+}
+
+// jobLogger adds the job to everything that is logged about it.
+func (e *Engine) jobLogger(job Job) *slog.Logger {
+	logger := e.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger = logger.With(
+		"deployment_id", job.Id,
+		"repo", githubclient.DefaultOwner+"/"+githubclient.DefaultRepo,
+		"branch", job.Branch,
+		"action", string(job.Action),
+		"trigger", job.Trigger,
+	)
+	if job.DeliveryId != "" {
+		logger = logger.With("delivery_id", job.DeliveryId)
+	}
+	if job.PrNumber != 0 {
+		logger = logger.With("pull_request", job.PrNumber)
+	}
+	return logger
+}
+
+func (e *Engine) server(branch string) Server {
+	if e.NewServer != nil {
+		return e.NewServer(branch)
+	}
+	server := staging.NewServer(branch, nil)
+	return &server
+}
+
+func (e *Engine) logUrl(branch string) string {
+	if e.PublicUrl == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/api/servers/%s/logs", e.PublicUrl, branch)
+}
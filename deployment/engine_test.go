@@ -0,0 +1,141 @@
+package deployment
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/vektorprogrammet/build-system/git"
+	"github.com/vektorprogrammet/build-system/staging"
+)
+
+type stubServer struct {
+	name      string
+	ref       string
+	folder    string
+	exists    bool
+	pinned    bool
+	changes   git.Comparison
+	updateErr error
+	rollbacks []string
+}
+
+func (s *stubServer) Exists() bool       { return s.exists }
+func (s *stubServer) IsPinned() bool     { return s.pinned }
+func (s *stubServer) ServerName() string { return s.name }
+func (s *stubServer) CurrentRef() string { return s.ref }
+func (s *stubServer) LogFile() string    { return filepath.Join(s.folder, "logs", "stub.log") }
+func (s *stubServer) HealthFile() string { return filepath.Join(s.folder, ".staging-health.json") }
+func (s *stubServer) Pin() error {
+	s.pinned = true
+	return nil
+}
+func (s *stubServer) Deploy() error {
+	s.exists = true
+	return nil
+}
+func (s *stubServer) Changes() (git.Comparison, error) { return s.changes, nil }
+func (s *stubServer) Update(c git.Comparison) error    { return s.updateErr }
+func (s *stubServer) Rollback(release string, restoreDatabase bool) (string, error) {
+	s.rollbacks = append(s.rollbacks, release)
+	return "previous", nil
+}
+func (s *stubServer) ResetDatabase() error { return nil }
+func (s *stubServer) Remove() error {
+	s.exists = false
+	s.ref = ""
+	return nil
+}
+
+func stubEngine(server *stubServer) *Engine {
+	e := NewEngine(nil, nil)
+	e.NewServer = func(branch string) Server { return server }
+	return e
+}
+
+func TestEngine_SkipsCommitsThatAreDeployed(t *testing.T) {
+	server := &stubServer{exists: true}
+	e := stubEngine(server)
+	push := Job{Action: Update, Branch: "feature", Sha: "abc", Trigger: TriggerPush}
+
+	if e.alreadyDeployed(push) {
+		t.Errorf("Expected a commit that was never deployed to run")
+	}
+	e.record(push, nil)
+	if !e.alreadyDeployed(push) {
+		t.Errorf("Expected a second push of the same commit to be skipped")
+	}
+
+	pullRequest := push
+	pullRequest.Trigger = TriggerPullRequest
+	if !e.alreadyDeployed(pullRequest) {
+		t.Errorf("Expected the pull request event of the same commit to be skipped")
+	}
+
+	manual := push
+	manual.Trigger = TriggerApi
+	if e.alreadyDeployed(manual) {
+		t.Errorf("Expected jobs that are not automatic to run")
+	}
+
+	newer := push
+	newer.Sha = "def"
+	if e.alreadyDeployed(newer) {
+		t.Errorf("Expected a new commit to run")
+	}
+
+	server.exists = false
+	if e.alreadyDeployed(push) {
+		t.Errorf("Expected the commit to be deployed again when the server is gone")
+	}
+}
+
+func TestEngine_Record(t *testing.T) {
+	server := &stubServer{exists: true}
+	e := stubEngine(server)
+	push := Job{Action: Update, Branch: "feature", Sha: "abc", Trigger: TriggerPush}
+
+	e.record(push, errors.New("build failed"))
+	if e.deployed["feature"] != "" {
+		t.Errorf("Expected failed jobs not to be recorded, got %s", e.deployed["feature"])
+	}
+
+	server.pinned = true
+	e.record(push, nil)
+	if e.deployed["feature"] != "" {
+		t.Errorf("Expected jobs on pinned servers not to be recorded, got %s", e.deployed["feature"])
+	}
+
+	server.pinned = false
+	e.record(push, nil)
+	if e.deployed["feature"] != "abc" {
+		t.Errorf("Expected abc to be recorded, got %q", e.deployed["feature"])
+	}
+	e.record(Job{Action: ResetDatabase, Branch: "feature"}, nil)
+	if e.deployed["feature"] != "abc" {
+		t.Errorf("Expected a database reset to keep the commit, got %q", e.deployed["feature"])
+	}
+
+	for _, action := range []Action{Rollback, Remove} {
+		e.record(push, nil)
+		e.record(Job{Action: action, Branch: "feature"}, nil)
+		if _, ok := e.deployed["feature"]; ok {
+			t.Errorf("Expected %s to forget the deployed commit", action)
+		}
+	}
+}
+
+func TestEngine_BusyComparesServerNames(t *testing.T) {
+	e := NewEngine(nil, nil)
+	e.setRunning(e.server("feature/Busy_Server").ServerName())
+
+	listed := staging.NewServer("featurebusy-server", nil)
+	other := staging.NewServer("feature", nil)
+
+	if !e.Busy(listed.ServerName()) {
+		t.Errorf("Expected the server listed from its folder to be busy")
+	}
+	if e.Busy(other.ServerName()) {
+		t.Errorf("Expected other servers not to be busy")
+	}
+}
@@ -0,0 +1,461 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"github.com/vektorprogrammet/build-system/git"
+	"github.com/vektorprogrammet/build-system/githubclient"
+	"github.com/vektorprogrammet/build-system/health"
+	"github.com/vektorprogrammet/build-system/messenger"
+	"github.com/vektorprogrammet/build-system/staging"
+	"github.com/vektorprogrammet/build-system/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const trackerLogLines = 30
+
+// execution holds the state of a single job while it runs.
+type execution struct {
+	engine    *Engine
+	job       Job
+	server    Server
+	ctx       context.Context
+	log       io.Writer
+	commenter *messenger.GithubCommenter
+	tracker   messenger.DeploymentTracker
+	comment   messenger.DeploymentTracker
+	logged    bool
+	health    string
+	logger    *slog.Logger
+}
+
+func newExecution(ctx context.Context, e *Engine, job Job) *execution {
+	x := &execution{engine: e, job: job, logger: e.jobLogger(job)}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		x.logger = x.logger.With("trace_id", span.TraceID().String())
+	}
+
+	x.ctx = ctx
+	x.server = e.server(job.Branch)
+	if server, ok := x.server.(*staging.Server); ok {
+		server.UpdateProgress = x.progress
+		server.Ref = job.Ref
+		server.Commit = job.Sha
+		server.Logger = x.logger
+		server.Context = ctx
+	}
+
+	if job.PrNumber != 0 {
+		x.commenter = messenger.NewGithubCommenter(e.Github, job.PrNumber)
+		x.commenter.Logger = x.logger
+	}
+	if e.Messenger != nil {
+		x.tracker = messenger.Track(e.Messenger, x.deployment())
+	}
+
+	return x
+}
+
+// keepRef makes the job deploy the ref the server is deployed from, like a pull
+// request from a fork, since the ref is lost when the server is removed.
+func (x *execution) keepRef() {
+	if x.job.Ref != "" {
+		return
+	}
+	x.job.Ref = x.server.CurrentRef()
+	if server, ok := x.server.(*staging.Server); ok {
+		server.Ref = x.job.Ref
+	}
+}
+
+func (x *execution) progress(message string, progress int) {
+	x.logger.Info(message, "progress", progress)
+	if x.comment != nil {
+		if err := x.comment.Progress(context.Background(), message, progress); err != nil {
+			x.logger.Warn("Could not update comment on pull request", "error", err)
+		}
+	}
+	if x.tracker != nil {
+		if err := x.tracker.Progress(context.Background(), message, progress); err != nil {
+			x.logger.Warn("Could not send progress", "error", err)
+		}
+	}
+}
+
+func (x *execution) deployment() messenger.Deployment {
+	return messenger.Deployment{
+		Repo:   githubclient.DefaultOwner + "/" + githubclient.DefaultRepo,
+		Branch: x.job.Branch,
+		LogUrl: x.engine.logUrl(x.job.Branch),
+	}
+}
+
+func (x *execution) run() error {
+	server := x.server
+	if err := git.CheckBranchName(x.job.Branch); err != nil {
+		return err
+	}
+	if server.Exists() && server.IsPinned() && x.job.automatic() {
+		x.logger.Info("Server is pinned, skipping job")
+		return nil
+	}
+
+	switch x.job.Action {
+	case Deploy:
+		var err error
+		if server.Exists() {
+			err = x.update()
+		} else {
+			err = x.deploy()
+		}
+		if err != nil || !x.job.Pin {
+			return err
+		}
+		x.logger.Info("Pinning server", "sha", x.job.Sha)
+		return server.Pin()
+	case Update:
+		if !server.Exists() && x.job.Trigger == TriggerPush {
+			x.logger.Debug("No staging server deployed for branch, ignoring push")
+			return nil
+		}
+		if !server.Exists() {
+			return fmt.Errorf("no staging server deployed for branch %s", x.job.Branch)
+		}
+		return x.update()
+	case Redeploy:
+		if server.Exists() {
+			x.keepRef()
+			if err := x.remove(); err != nil {
+				return err
+			}
+		}
+		return x.deploy()
+	case Remove:
+		if !server.Exists() {
+			return fmt.Errorf("no staging server deployed for branch %s", x.job.Branch)
+		}
+		return x.remove()
+	case ResetDatabase:
+		if !server.Exists() {
+			return fmt.Errorf("no staging server deployed for branch %s", x.job.Branch)
+		}
+		return x.resetDatabase()
+	case Rollback:
+		if !server.Exists() {
+			return fmt.Errorf("no staging server deployed for branch %s", x.job.Branch)
+		}
+		return x.rollback()
+	}
+
+	return fmt.Errorf("unknown action %s", x.job.Action)
+}
+
+func (x *execution) deploy() error {
+	closeLog := x.openLog()
+	defer closeLog()
+
+	githubDeployment := x.startGithubDeployment("Deploying staging server")
+	if x.commenter != nil {
+		x.comment = x.commenter.TrackDeployment(x.deployment())
+	}
+
+	err := x.server.Deploy()
+	if err != nil {
+		x.finish(fmt.Sprintf("Could not create staging server: %s", err), err, false)
+		x.failGithubDeployment(githubDeployment, err)
+		x.server.Remove()
+		return err
+	}
+
+	if err := x.verify(); err != nil {
+		x.finish(fmt.Sprintf("Staging server deployed at https://%s, but it is not healthy", x.server.ServerName()), err, true)
+		x.failGithubDeployment(githubDeployment, err)
+		return err
+	}
+
+	x.succeedGithubDeployment(githubDeployment)
+	x.finish(fmt.Sprintf("Staging server deployed at https://%s", x.server.ServerName()), nil, true)
+	return nil
+}
+
+func (x *execution) update() error {
+	changes, err := x.server.Changes()
+	if err != nil {
+		x.finish(fmt.Sprintf("Could not compare staging server with origin: %s", err), err, true)
+		return err
+	}
+	if changes.Status == git.UpToDate {
+		x.logger.Info("Server is up to date with origin", "sha", changes.Head)
+		return nil
+	}
+
+	closeLog := x.openLog()
+	defer closeLog()
+
+	githubDeployment := x.startGithubDeployment("Updating staging server")
+	if err := x.server.Update(changes); err != nil {
+		x.finish(fmt.Sprintf("Could not update staging server: %s", err), err, true)
+		x.failGithubDeployment(githubDeployment, err)
+		return err
+	}
+
+	if err := x.verify(); err != nil {
+		message := "Staging server is not healthy after the update"
+		if release, rollbackErr := x.server.Rollback("", false); rollbackErr != nil {
+			message += fmt.Sprintf(" and could not be rolled back: %s", rollbackErr)
+		} else {
+			message += ", rolled back to " + release
+		}
+		x.finish(message, err, true)
+		x.failGithubDeployment(githubDeployment, err)
+		return err
+	}
+
+	x.succeedGithubDeployment(githubDeployment)
+	x.finish(fmt.Sprintf("Staging server updated at https://%s", x.server.ServerName()), nil, true)
+	return nil
+}
+
+// verify runs the health check of the engine against the server and saves the
+// report for the API.
+func (x *execution) verify() error {
+	if x.engine.HealthCheck == nil {
+		return nil
+	}
+
+	x.progress("Checking that the server responds", 95)
+	_, span := tracing.Start(x.ctx, "health check")
+	report := x.engine.HealthCheck.Run("https://" + x.server.ServerName())
+	var err error
+	if !report.Healthy {
+		err = fmt.Errorf("health check failed")
+	}
+	tracing.End(span, err)
+
+	x.health = report.String()
+	if x.log != nil {
+		fmt.Fprintf(x.log, "# Health check\n%s\n", x.health)
+	}
+	if err := health.SaveReport(x.server.HealthFile(), report); err != nil {
+		x.logger.Warn("Could not save health check", "error", err)
+	}
+	return err
+}
+
+func (x *execution) remove() error {
+	if err := x.server.Remove(); err != nil {
+		x.finish("Could not remove branch", err, false)
+		return err
+	}
+
+	if err := messenger.NewGithubDeployment(x.engine.Github, x.job.Branch, "").Deactivate(); err != nil {
+		x.logger.Warn("Could not deactivate GitHub deployments", "error", err)
+	}
+	if x.job.Action == Remove {
+		x.finish("Staging server deleted", nil, false)
+	} else {
+		x.progress("Removed old staging server", 0)
+	}
+	return nil
+}
+
+func (x *execution) resetDatabase() error {
+	closeLog := x.openLog()
+	defer closeLog()
+
+	if err := x.server.ResetDatabase(); err != nil {
+		x.finish(fmt.Sprintf("Could not reset database: %s", err), err, true)
+		return err
+	}
+
+	x.finish("Database reset", nil, true)
+	return nil
+}
+
+func (x *execution) rollback() error {
+	closeLog := x.openLog()
+	defer closeLog()
+
+	if x.commenter == nil {
+		if number := x.pullRequest(); number != 0 {
+			x.commenter = messenger.NewGithubCommenter(x.engine.Github, number)
+		}
+	}
+	if x.commenter != nil {
+		x.comment = x.commenter.TrackDeployment(x.deployment())
+	}
+
+	release, err := x.server.Rollback(x.job.Release, x.job.RestoreDatabase)
+	if err != nil {
+		x.finish(fmt.Sprintf("Could not roll back staging server: %s", err), err, true)
+		return err
+	}
+
+	x.job.Sha = release
+	githubDeployment := x.startGithubDeployment("Rolling back staging server")
+	x.succeedGithubDeployment(githubDeployment)
+	message := fmt.Sprintf("Staging server rolled back to %s at https://%s", release, x.server.ServerName())
+	if x.job.RestoreDatabase {
+		message += " with the database from before the newer releases"
+	}
+	x.finish(message, nil, true)
+	return nil
+}
+
+// pullRequest finds the open pull request of the branch, so jobs that were not
+// triggered from a pull request can still be reported on it. Servers of
+// pull requests from forks are named pr-<number>.
+func (x *execution) pullRequest() int {
+	if strings.HasPrefix(x.job.Branch, "pr-") {
+		if number, err := strconv.Atoi(strings.TrimPrefix(x.job.Branch, "pr-")); err == nil {
+			return number
+		}
+	}
+	if x.engine.Github == nil {
+		return 0
+	}
+	ctx := context.Background()
+	client, err := x.engine.Github.Client(ctx, githubclient.DefaultOwner, githubclient.DefaultRepo)
+	if err != nil {
+		x.logger.Warn("Could not find pull request", "error", err)
+		return 0
+	}
+	pulls, _, err := client.PullRequests.List(ctx, githubclient.DefaultOwner, githubclient.DefaultRepo, &github.PullRequestListOptions{
+		State: "open",
+		Head:  githubclient.DefaultOwner + ":" + x.job.Branch,
+	})
+	if err != nil || len(pulls) == 0 {
+		return 0
+	}
+	return pulls[0].GetNumber()
+}
+
+func (x *execution) reply(err error) {
+	reply := fmt.Sprintf("`/staging %s` finished: https://%s", x.job.Action, x.server.ServerName())
+	if x.job.Action == Remove {
+		reply = "`/staging destroy` finished: Staging server deleted"
+	}
+	if err != nil {
+		reply = fmt.Sprintf("`/staging %s` failed: %s", x.job.Action, err)
+		if logUrl := x.engine.logUrl(x.job.Branch); logUrl != "" {
+			reply += fmt.Sprintf("\n\n[Deploy log](%s)", logUrl)
+		}
+	}
+
+	if _, err := x.commenter.Comment(reply); err != nil {
+		x.logger.Warn("Could not reply on pull request", "error", err)
+	}
+}
+
+func (x *execution) openLog() func() {
+	if err := os.MkdirAll(path.Dir(x.server.LogFile()), 0755); err != nil {
+		x.logger.Error("Could not create log folder", "error", err)
+		return func() {}
+	}
+
+	logFile, err := os.Create(x.server.LogFile())
+	if err != nil {
+		x.logger.Error("Could not create log file", "error", err)
+		return func() {}
+	}
+
+	x.setLog(logFile)
+	x.logged = true
+	return func() {
+		x.setLog(nil)
+		logFile.Close()
+	}
+}
+
+func (x *execution) setLog(w io.Writer) {
+	x.log = w
+	if server, ok := x.server.(*staging.Server); ok {
+		server.Log = w
+	}
+}
+
+func (x *execution) startGithubDeployment(description string) *messenger.GithubDeployment {
+	githubDeployment := messenger.NewGithubDeployment(x.engine.Github, x.job.Branch, x.job.Sha)
+	if err := githubDeployment.Start(description); err != nil {
+		x.logger.Warn("Could not create GitHub deployment", "error", err)
+	}
+	return githubDeployment
+}
+
+func (x *execution) succeedGithubDeployment(githubDeployment *messenger.GithubDeployment) {
+	if err := githubDeployment.Succeed("https://"+x.server.ServerName(), x.engine.logUrl(x.job.Branch)); err != nil {
+		x.logger.Warn("Could not update GitHub deployment", "error", err)
+	}
+}
+
+func (x *execution) failGithubDeployment(githubDeployment *messenger.GithubDeployment, cause error) {
+	if err := githubDeployment.Fail(x.engine.logUrl(x.job.Branch), cause); err != nil {
+		x.logger.Warn("Could not update GitHub deployment", "error", err)
+	}
+}
+
+// finish reports the outcome of the job. Servers that are still running get
+// buttons to open, redeploy and destroy them.
+func (x *execution) finish(message string, err error, withActions bool) {
+	var actions []messenger.Action
+	if withActions {
+		actions = x.actions()
+	}
+
+	ctx := context.Background()
+	logs := x.logTail()
+	for _, tracker := range []messenger.DeploymentTracker{x.tracker, x.comment} {
+		if tracker == nil {
+			continue
+		}
+		if err != nil {
+			tracker.Log(ctx, fmt.Sprintf("Error: %s", err))
+		}
+		if logs != "" {
+			tracker.Log(ctx, logs)
+		}
+		if x.health != "" {
+			tracker.Log(ctx, "Health check:\n"+x.health)
+		}
+		if err := tracker.Finish(ctx, message, err, actions); err != nil {
+			x.logger.Warn("Could not send result", "error", err)
+		}
+	}
+}
+
+func (x *execution) actions() []messenger.Action {
+	actions := []messenger.Action{
+		{Id: "open", Text: "Open", Url: "https://" + x.server.ServerName()},
+		{Id: string(Redeploy), Text: "Redeploy", Value: x.job.Branch},
+		{Id: string(Remove), Text: "Destroy", Value: x.job.Branch, Dangerous: true},
+	}
+	if logUrl := x.engine.logUrl(x.job.Branch); logUrl != "" {
+		actions = append(actions, messenger.Action{Id: "logs", Text: "View logs", Url: logUrl})
+	}
+	return actions
+}
+
+func (x *execution) logTail() string {
+	if !x.logged {
+		return ""
+	}
+	logs, err := ioutil.ReadFile(x.server.LogFile())
+	if err != nil || len(logs) == 0 {
+		return ""
+	}
+
+	lines := strings.Split(strings.TrimRight(string(logs), "\n"), "\n")
+	if len(lines) > trackerLogLines {
+		lines = lines[len(lines)-trackerLogLines:]
+	}
+	return "```\n" + strings.Join(lines, "\n") + "\n```"
+}
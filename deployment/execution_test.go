@@ -0,0 +1,111 @@
+package deployment
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/vektorprogrammet/build-system/git"
+	"github.com/vektorprogrammet/build-system/githubclient"
+	"github.com/vektorprogrammet/build-system/health"
+)
+
+// healthCheckEngine runs jobs on server against a site that responds with
+// status, and a GitHub that knows nothing.
+func healthCheckEngine(t *testing.T, server *stubServer, status int) (*Engine, func()) {
+	site := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	github := httptest.NewServer(http.NotFoundHandler())
+
+	dir, err := ioutil.TempDir("", "deployment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.folder = dir
+	server.name = strings.TrimPrefix(site.URL, "https://")
+
+	e := stubEngine(server)
+	e.Github = &githubclient.TokenFactory{BaseURL: github.URL + "/"}
+	e.HealthCheck = &health.Check{Paths: []string{"/"}, Status: http.StatusOK, Client: site.Client()}
+	return e, func() {
+		site.Close()
+		github.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestEngine_RollsBackUnhealthyUpdates(t *testing.T) {
+	server := &stubServer{exists: true, changes: git.Comparison{Status: git.FastForward, Head: "aaa", Target: "bbb"}}
+	e, cleanup := healthCheckEngine(t, server, http.StatusInternalServerError)
+	defer cleanup()
+
+	err := e.Run(Job{Action: Update, Branch: "feature", Trigger: TriggerApi})
+	if err == nil {
+		t.Fatal("Expected an unhealthy update to fail")
+	}
+	if len(server.rollbacks) != 1 || server.rollbacks[0] != "" {
+		t.Errorf("Expected a rollback to the previous release, got %v", server.rollbacks)
+	}
+	report, err := health.LoadReport(server.HealthFile())
+	if err != nil || report == nil || report.Healthy {
+		t.Errorf("Expected the failed health check to be saved, got %+v, %v", report, err)
+	}
+}
+
+func TestEngine_KeepsHealthyUpdates(t *testing.T) {
+	server := &stubServer{exists: true, changes: git.Comparison{Status: git.FastForward, Head: "aaa", Target: "bbb"}}
+	e, cleanup := healthCheckEngine(t, server, http.StatusOK)
+	defer cleanup()
+
+	if err := e.Run(Job{Action: Update, Branch: "feature", Trigger: TriggerApi}); err != nil {
+		t.Fatalf("Expected a healthy update to succeed, got %s", err)
+	}
+	if len(server.rollbacks) != 0 {
+		t.Errorf("Expected no rollback, got %v", server.rollbacks)
+	}
+}
+
+func TestEngine_DoesNotRollBackUnhealthyDeploys(t *testing.T) {
+	server := &stubServer{}
+	e, cleanup := healthCheckEngine(t, server, http.StatusInternalServerError)
+	defer cleanup()
+
+	if err := e.Run(Job{Action: Deploy, Branch: "feature", Trigger: TriggerApi}); err == nil {
+		t.Fatal("Expected an unhealthy deploy to fail")
+	}
+	if len(server.rollbacks) != 0 || !server.exists {
+		t.Errorf("Expected the new server to be kept for debugging, got rollbacks %v", server.rollbacks)
+	}
+}
+
+func TestEngine_RedeploysForksFromTheirRef(t *testing.T) {
+	server := &stubServer{exists: true, ref: "refs/pull/42/head"}
+	e, cleanup := healthCheckEngine(t, server, http.StatusOK)
+	defer cleanup()
+
+	x := newExecution(context.Background(), e, Job{Action: Redeploy, Branch: "pr-42", Trigger: TriggerApi})
+	if err := x.run(); err != nil {
+		t.Fatalf("Expected the redeploy to succeed, got %s", err)
+	}
+	if x.job.Ref != "refs/pull/42/head" || !server.exists {
+		t.Errorf("Expected the fork to be deployed again from its ref, got %q", x.job.Ref)
+	}
+}
+
+func TestEngine_PinsDeploysOfARef(t *testing.T) {
+	server := &stubServer{}
+	e, cleanup := healthCheckEngine(t, server, http.StatusOK)
+	defer cleanup()
+
+	if err := e.Run(Job{Action: Deploy, Branch: "feature", Sha: "abc", Pin: true, Trigger: TriggerApi}); err != nil {
+		t.Fatalf("Expected the deploy to succeed, got %s", err)
+	}
+	if !server.pinned {
+		t.Errorf("Expected the server to be pinned")
+	}
+}
@@ -0,0 +1,198 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Status is how the checked out commit relates to the commit that should be
+// deployed.
+type Status int
+
+const (
+	// UpToDate means HEAD is the target commit.
+	UpToDate Status = iota
+	// FastForward means the target has new commits on top of HEAD.
+	FastForward
+	// Diverged means HEAD and the target have both got commits the other
+	// does not have, like after a rebase.
+	Diverged
+	// ForcePushed means the target is behind HEAD, so the remote was reset
+	// to an older commit.
+	ForcePushed
+	// MissingRemote means the remote ref does not exist anymore.
+	MissingRemote
+)
+
+func (s Status) String() string {
+	switch s {
+	case UpToDate:
+		return "up to date"
+	case FastForward:
+		return "fast-forward"
+	case Diverged:
+		return "diverged"
+	case ForcePushed:
+		return "force-pushed"
+	case MissingRemote:
+		return "missing remote"
+	}
+	return fmt.Sprintf("Status(%d)", int(s))
+}
+
+type Comparison struct {
+	Status Status
+	Head   string
+	Target string
+}
+
+// unsafeBranchCharacters are allowed in branch names by git, but have a
+// meaning to the shell. Branch names end up in database names and commands,
+// so they are rejected.
+const unsafeBranchCharacters = ";&|$`'\"<>(){}!#%=,"
+
+// CheckBranchName returns an error if name is not a valid branch name, or if it
+// contains characters that are unsafe to use in commands.
+func CheckBranchName(name string) error {
+	if name == "" || strings.HasPrefix(name, "-") || strings.ContainsAny(name, unsafeBranchCharacters) {
+		return fmt.Errorf("invalid branch name %q", name)
+	}
+	if _, err := (Repo{}).git("check-ref-format", "--branch", name); err != nil {
+		return fmt.Errorf("invalid branch name %q", name)
+	}
+	return nil
+}
+
+// Repo is a working copy in Dir.
+type Repo struct {
+	Dir string
+}
+
+// Head returns the SHA of the checked out commit.
+func (r Repo) Head() (string, error) {
+	return r.git("rev-parse", "--verify", "HEAD")
+}
+
+// RemoteSha returns the SHA a ref like refs/heads/master points to on the
+// remote, or an empty string if the ref does not exist.
+func (r Repo) RemoteSha(remote, ref string) (string, error) {
+	output, err := r.git("ls-remote", remote, ref)
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[1] == ref {
+			return fields[0], nil
+		}
+	}
+	return "", nil
+}
+
+func (r Repo) Fetch(remote, ref string) error {
+	_, err := r.git("fetch", remote, ref)
+	return err
+}
+
+// HasCommit is true if the commit is in the local object database.
+func (r Repo) HasCommit(sha string) bool {
+	_, err := r.git("cat-file", "-e", sha+"^{commit}")
+	return err == nil
+}
+
+// IsAncestor is true if ancestor is reachable from commit.
+func (r Repo) IsAncestor(ancestor, commit string) (bool, error) {
+	_, err := r.git("merge-base", "--is-ancestor", ancestor, commit)
+	if gitErr, ok := err.(*Error); ok && gitErr.ExitCode() == 1 {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Compare compares HEAD with a target commit that has already been fetched.
+func (r Repo) Compare(target string) (Comparison, error) {
+	head, err := r.Head()
+	if err != nil {
+		return Comparison{}, err
+	}
+	c := Comparison{Head: head, Target: target}
+	if head == target {
+		c.Status = UpToDate
+		return c, nil
+	}
+
+	if fastForward, err := r.IsAncestor(head, target); err != nil || fastForward {
+		c.Status = FastForward
+		return c, err
+	}
+	if behind, err := r.IsAncestor(target, head); err != nil || behind {
+		c.Status = ForcePushed
+		return c, err
+	}
+	c.Status = Diverged
+	return c, nil
+}
+
+// CompareRemote fetches ref from the remote and compares HEAD with it, or
+// with commit if it is set.
+func (r Repo) CompareRemote(remote, ref, commit string) (Comparison, error) {
+	remoteSha, err := r.RemoteSha(remote, ref)
+	if err != nil {
+		return Comparison{}, err
+	}
+	if remoteSha == "" {
+		return Comparison{Status: MissingRemote}, nil
+	}
+
+	if err := r.Fetch(remote, ref); err != nil {
+		return Comparison{}, err
+	}
+	target := remoteSha
+	if commit != "" {
+		target = commit
+		if !r.HasCommit(target) {
+			if err := r.Fetch(remote, target); err != nil {
+				return Comparison{}, err
+			}
+		}
+	}
+
+	return r.Compare(target)
+}
+
+func (r Repo) git(args ...string) (string, error) {
+	var stderr bytes.Buffer
+	c := exec.Command("git", args...)
+	c.Dir = r.Dir
+	c.Env = append(os.Environ(), "LC_ALL=C", "GIT_TERMINAL_PROMPT=0")
+	c.Stderr = &stderr
+	output, err := c.Output()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return "", &Error{Args: args, Stderr: strings.TrimSpace(stderr.String()), err: err}
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+type Error struct {
+	Args   []string
+	Stderr string
+	err    error
+}
+
+func (e *Error) ExitCode() int {
+	if exitErr, ok := e.err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("git %s: %s: %s", strings.Join(e.Args, " "), e.err, e.Stderr)
+}
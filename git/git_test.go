@@ -0,0 +1,139 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// testRepos creates a bare origin with one commit on master and a clone of it.
+func testRepos(t *testing.T) (string, Repo, func()) {
+	root, err := ioutil.TempDir("", "git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	origin := filepath.Join(root, "origin.git")
+	work := filepath.Join(root, "work")
+	clone := filepath.Join(root, "clone")
+
+	run(t, root, "init", "--bare", "-b", "master", origin)
+	run(t, root, "clone", origin, work)
+	commit(t, work, "first")
+	run(t, work, "push", "origin", "HEAD:master")
+	run(t, root, "clone", origin, clone)
+
+	return work, Repo{Dir: clone}, func() { os.RemoveAll(root) }
+}
+
+func run(t *testing.T, dir string, args ...string) {
+	c := exec.Command("git", args...)
+	c.Dir = dir
+	c.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+	if output, err := c.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %s\n%s", args, err, output)
+	}
+}
+
+func commit(t *testing.T, dir, message string) {
+	run(t, dir, "commit", "--allow-empty", "-m", message)
+}
+
+func TestCompareRemote(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   func(t *testing.T, work string)
+		ref      string
+		expected Status
+	}{
+		{"up to date", func(t *testing.T, work string) {}, "refs/heads/master", UpToDate},
+		{"fast-forward", func(t *testing.T, work string) {
+			commit(t, work, "second")
+			run(t, work, "push", "origin", "HEAD:master")
+		}, "refs/heads/master", FastForward},
+		{"diverged", func(t *testing.T, work string) {
+			run(t, work, "commit", "--allow-empty", "--amend", "-m", "amended")
+			run(t, work, "push", "--force", "origin", "HEAD:master")
+		}, "refs/heads/master", Diverged},
+		{"missing remote", func(t *testing.T, work string) {}, "refs/heads/deleted", MissingRemote},
+	}
+
+	for _, test := range tests {
+		work, repo, cleanup := testRepos(t)
+		test.change(t, work)
+
+		c, err := repo.CompareRemote("origin", test.ref, "")
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if c.Status != test.expected {
+			t.Errorf("%s: Expected %s, got %s", test.name, test.expected, c.Status)
+		}
+		cleanup()
+	}
+}
+
+func TestCompareRemote_ForcePushed(t *testing.T) {
+	work, repo, cleanup := testRepos(t)
+	defer cleanup()
+
+	commit(t, work, "second")
+	run(t, work, "push", "origin", "HEAD:master")
+	run(t, repo.Dir, "pull", "origin", "master")
+	run(t, work, "reset", "--hard", "HEAD~1")
+	run(t, work, "push", "--force", "origin", "HEAD:master")
+
+	c, err := repo.CompareRemote("origin", "refs/heads/master", "")
+	if err != nil || c.Status != ForcePushed {
+		t.Errorf("Expected %s, got %s, %v", ForcePushed, c.Status, err)
+	}
+}
+
+func TestCompareRemote_Commit(t *testing.T) {
+	work, repo, cleanup := testRepos(t)
+	defer cleanup()
+
+	first, _ := Repo{Dir: work}.Head()
+	commit(t, work, "second")
+	run(t, work, "push", "origin", "HEAD:master")
+
+	c, err := repo.CompareRemote("origin", "refs/heads/master", first)
+	if err != nil || c.Status != UpToDate || c.Target != first {
+		t.Errorf("Expected to be up to date with %s, got %+v, %v", first, c, err)
+	}
+}
+
+func TestMirror_Update(t *testing.T) {
+	work, _, cleanup := testRepos(t)
+	defer cleanup()
+
+	mirror := Mirror{Path: filepath.Join(filepath.Dir(work), "mirrors", "origin.git"), Url: filepath.Join(filepath.Dir(work), "origin.git")}
+	if err := mirror.Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	commit(t, work, "second")
+	run(t, work, "push", "origin", "HEAD:refs/heads/feature")
+	if err := mirror.Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected, _ := Repo{Dir: work}.Head()
+	if sha, err := (Repo{Dir: work}).RemoteSha(mirror.Path, "refs/heads/feature"); err != nil || sha != expected {
+		t.Errorf("Expected mirror to have feature at %s, got %q, %v", expected, sha, err)
+	}
+}
+
+func TestCheckBranchName(t *testing.T) {
+	for _, name := range []string{"feature", "feature/login", "release/2018-autumn", "pr-42", "fix_typo.v2"} {
+		if err := CheckBranchName(name); err != nil {
+			t.Errorf("Expected %q to be valid, got %s", name, err)
+		}
+	}
+	for _, name := range []string{"", "-x", "a..b", "a b", "feature.lock", "x;rm -rf /", "$(id)", "`id`", "a|b", "a&b", "a'b"} {
+		if err := CheckBranchName(name); err == nil {
+			t.Errorf("Expected %q to be invalid", name)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Mirror is a bare mirror of a remote repository. Working copies are cloned
+// from it, so each event only fetches from the remote once and clones only
+// copy objects locally. The mirror keeps the full history, since comparing
+// commits needs it.
+type Mirror struct {
+	Path string
+	Url  string
+}
+
+// Update creates the mirror or fetches all refs from the remote.
+func (m Mirror) Update() error {
+	if _, err := os.Stat(m.Path); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(m.Path), 0755); err != nil {
+			return err
+		}
+		_, err := Repo{Dir: filepath.Dir(m.Path)}.git("clone", "--mirror", m.Url, m.Path)
+		return err
+	}
+
+	_, err := Repo{Dir: m.Path}.git("fetch", "--prune", "origin")
+	return err
+}
@@ -0,0 +1,179 @@
+package githubclient
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+type installationToken struct {
+	token     string
+	expiresAt time.Time
+}
+
+// AppFactory authenticates as a GitHub App installation. Installation ids
+// are looked up per repository and tokens are cached until shortly before
+// they expire.
+type AppFactory struct {
+	AppId      int64
+	PrivateKey *rsa.PrivateKey
+	BaseURL    string
+
+	mu            sync.Mutex
+	installations map[string]int64
+	tokens        map[int64]installationToken
+	now           func() time.Time
+}
+
+func NewAppFactory(appId int64, privateKeyPem []byte) (*AppFactory, error) {
+	block, _ := pem.Decode(privateKeyPem)
+	if block == nil {
+		return nil, errors.New("could not decode GitHub App private key")
+	}
+	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AppFactory{
+		AppId:         appId,
+		PrivateKey:    privateKey,
+		installations: make(map[string]int64),
+		tokens:        make(map[int64]installationToken),
+		now:           time.Now,
+	}, nil
+}
+
+func (a *AppFactory) Client(ctx context.Context, owner, repo string) (*github.Client, error) {
+	installationId, err := a.installationId(ctx, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	return newClient(&http.Client{
+		Transport: &installationTransport{app: a, installationId: installationId},
+	}, a.BaseURL)
+}
+
+func (a *AppFactory) installationId(ctx context.Context, owner, repo string) (int64, error) {
+	a.mu.Lock()
+	id, ok := a.installations[owner+"/"+repo]
+	a.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	client, err := a.appClient()
+	if err != nil {
+		return 0, err
+	}
+	installation, _, err := client.Apps.FindRepositoryInstallation(ctx, owner, repo)
+	if err != nil {
+		return 0, err
+	}
+
+	a.mu.Lock()
+	a.installations[owner+"/"+repo] = installation.GetID()
+	a.mu.Unlock()
+	return installation.GetID(), nil
+}
+
+func (a *AppFactory) token(ctx context.Context, installationId int64) (string, error) {
+	a.mu.Lock()
+	cached, ok := a.tokens[installationId]
+	a.mu.Unlock()
+	if ok && a.now().Add(time.Minute).Before(cached.expiresAt) {
+		return cached.token, nil
+	}
+
+	client, err := a.appClient()
+	if err != nil {
+		return "", err
+	}
+	token, _, err := client.Apps.CreateInstallationToken(ctx, installationId)
+	if err != nil {
+		return "", err
+	}
+
+	a.mu.Lock()
+	a.tokens[installationId] = installationToken{
+		token:     token.GetToken(),
+		expiresAt: token.GetExpiresAt(),
+	}
+	a.mu.Unlock()
+	return token.GetToken(), nil
+}
+
+func (a *AppFactory) appClient() (*github.Client, error) {
+	jwt, err := a.jwt()
+	if err != nil {
+		return nil, err
+	}
+
+	return newClient(&http.Client{
+		Transport: &authorizationTransport{authorization: "Bearer " + jwt},
+	}, a.BaseURL)
+}
+
+func (a *AppFactory) jwt() (string, error) {
+	now := a.now()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]int64{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": a.AppId,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.PrivateKey, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+type authorizationTransport struct {
+	authorization string
+}
+
+func (t *authorizationTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r2 := r.Clone(r.Context())
+	r2.Header.Set("Authorization", t.authorization)
+	return http.DefaultTransport.RoundTrip(r2)
+}
+
+type installationTransport struct {
+	app            *AppFactory
+	installationId int64
+}
+
+func (t *installationTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	token, err := t.app.token(r.Context(), t.installationId)
+	if err != nil {
+		return nil, err
+	}
+
+	r2 := r.Clone(r.Context())
+	r2.Header.Set("Authorization", "token "+token)
+	return http.DefaultTransport.RoundTrip(r2)
+}
@@ -0,0 +1,86 @@
+package githubclient
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/google/go-github/github"
+	"golang.org/x/oauth2"
+)
+
+const (
+	DefaultOwner = "vektorprogrammet"
+	DefaultRepo  = "vektorprogrammet"
+)
+
+type Factory interface {
+	Client(ctx context.Context, owner, repo string) (*github.Client, error)
+}
+
+// TokenFactory authenticates with a personal access token, or not at all if
+// the token is empty. The client is created once and shared by all
+// repositories.
+type TokenFactory struct {
+	Token   string
+	BaseURL string
+
+	mu     sync.Mutex
+	client *github.Client
+}
+
+func (t *TokenFactory) Client(ctx context.Context, owner, repo string) (*github.Client, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client != nil {
+		return t.client, nil
+	}
+
+	var httpClient *http.Client
+	if t.Token != "" {
+		httpClient = oauth2.NewClient(context.Background(), oauth2.StaticTokenSource(&oauth2.Token{AccessToken: t.Token}))
+	}
+	client, err := newClient(httpClient, t.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	t.client = client
+	return client, nil
+}
+
+// FromEnv uses GitHub App authentication when GITHUB_APP_ID and
+// GITHUB_APP_PRIVATE_KEY_PATH are set, and GITHUB_ACCESS_TOKEN otherwise.
+func FromEnv() (Factory, error) {
+	appId := os.Getenv("GITHUB_APP_ID")
+	privateKeyPath := os.Getenv("GITHUB_APP_PRIVATE_KEY_PATH")
+	if appId == "" || privateKeyPath == "" {
+		return &TokenFactory{Token: os.Getenv("GITHUB_ACCESS_TOKEN")}, nil
+	}
+
+	id, err := strconv.ParseInt(appId, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := ioutil.ReadFile(privateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewAppFactory(id, privateKey)
+}
+
+func newClient(httpClient *http.Client, baseURL string) (*github.Client, error) {
+	client := github.NewClient(httpClient)
+	if baseURL != "" {
+		u, err := url.Parse(baseURL)
+		if err != nil {
+			return nil, err
+		}
+		client.BaseURL = u
+	}
+	return client, nil
+}
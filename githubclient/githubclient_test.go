@@ -0,0 +1,22 @@
+package githubclient
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTokenFactory_ReusesClient(t *testing.T) {
+	factory := &TokenFactory{Token: "token", BaseURL: "https://github.example.com/api/v3/"}
+
+	first, err := factory.Client(context.Background(), DefaultOwner, DefaultRepo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := factory.Client(context.Background(), DefaultOwner, "another-repo")
+	if first != second {
+		t.Error("Expected the same client to be reused")
+	}
+	if first.BaseURL.String() != "https://github.example.com/api/v3/" {
+		t.Errorf("Expected the base URL to be used, got %s", first.BaseURL)
+	}
+}
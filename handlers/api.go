@@ -2,43 +2,54 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
+	"io"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
-	"os/exec"
-	"strconv"
-	"strings"
+	"os"
+	"syscall"
 
 	"github.com/gorilla/mux"
+	"github.com/vektorprogrammet/build-system/auth"
+	"github.com/vektorprogrammet/build-system/deployment"
+	"github.com/vektorprogrammet/build-system/githubclient"
+	"github.com/vektorprogrammet/build-system/logging"
 	"github.com/vektorprogrammet/build-system/staging"
 )
 
 type Api struct {
-	Router *mux.Router
+	Router      *mux.Router
+	Auth        auth.Authenticator
+	Deployments deployment.Queue
+	Webhooks    *WebhookHandler
+	Github      githubclient.Factory
+	// Logger defaults to slog.Default.
+	Logger *slog.Logger
 }
 
 func (a *Api) InitRoutes() {
-	a.Router.HandleFunc("/servers", a.handleGetServers)
-	a.Router.HandleFunc("/disk-space", a.handleGetDiskSpace)
+	a.Router.HandleFunc("/servers", auth.Require(a.Auth, auth.Viewer, a.handleGetServers)).Methods("GET")
+	a.Router.HandleFunc("/servers/{branch:.+}/logs", auth.Require(a.Auth, auth.Viewer, a.handleGetServerLogs)).Methods("GET")
+	a.Router.HandleFunc("/disk-space", auth.Require(a.Auth, auth.Viewer, a.handleGetDiskSpace)).Methods("GET")
+
+	a.Router.HandleFunc("/servers", auth.Require(a.Auth, auth.Deployer, a.handleDeployServer)).Methods("POST")
+	a.Router.HandleFunc("/servers/{branch:.+}/update", auth.Require(a.Auth, auth.Deployer, a.handleServerAction(deployment.Update))).Methods("POST")
+	a.Router.HandleFunc("/servers/{branch:.+}/redeploy", auth.Require(a.Auth, auth.Deployer, a.handleServerAction(deployment.Redeploy))).Methods("POST")
+	a.Router.HandleFunc("/servers/{branch:.+}/rollback", auth.Require(a.Auth, auth.Deployer, a.handleRollbackServer)).Methods("POST")
+	a.Router.HandleFunc("/servers/{branch:.+}", auth.Require(a.Auth, auth.Admin, a.handleServerAction(deployment.Remove))).Methods("DELETE")
+	a.Router.HandleFunc("/deliveries/{id}/replay", auth.Require(a.Auth, auth.Admin, a.handleReplayDelivery)).Methods("POST")
 }
 
 func (a *Api) handleGetServers(w http.ResponseWriter, r *http.Request) {
-	files, err := ioutil.ReadDir(staging.DefaultRootFolder)
+	servers, err := listServers()
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	var servers []staging.Server
-	for _, f := range files {
-		if f.IsDir() {
-			servers = append(servers, staging.NewServer(f.Name(), func(message string, progress int) {}))
-		}
-	}
-
 	serversJson, err := json.Marshal(servers)
 	if err != nil {
-		fmt.Println(err.Error())
+		a.log(r).Error("Could not encode servers", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -48,10 +59,153 @@ func (a *Api) handleGetServers(w http.ResponseWriter, r *http.Request) {
 	w.Write(serversJson)
 }
 
+func (a *Api) handleGetServerLogs(w http.ResponseWriter, r *http.Request) {
+	server := staging.NewServer(mux.Vars(r)["branch"], nil)
+	logs, err := ioutil.ReadFile(server.LogFile())
+	if os.IsNotExist(err) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		a.log(r).Error("Could not read server log", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(logs)
+}
+
+func (a *Api) handleDeployServer(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Branch string `json:"branch"`
+		// Ref is a commit, tag or branch that is deployed instead of the head
+		// of Branch. The server is pinned to it.
+		Ref string `json:"ref"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Branch == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err := ensureBranchExists(a.Github, request.Branch); err != nil {
+		a.log(r).Info("Not deploying unknown branch", "branch", request.Branch, "error", err)
+		http.Error(w, "Unknown branch", http.StatusBadRequest)
+		return
+	}
+
+	job := deployment.Job{
+		Action:  deployment.Deploy,
+		Branch:  request.Branch,
+		Trigger: deployment.TriggerApi,
+	}
+	if request.Ref == "" {
+		// Deploying the branch makes the server follow it again.
+		server := staging.NewServer(request.Branch, nil)
+		if err := server.Unpin(); err != nil {
+			a.log(r).Error("Could not unpin server", "branch", request.Branch, "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	} else {
+		sha, err := commitSha(a.Github, request.Ref)
+		if err != nil {
+			a.log(r).Info("Not deploying unknown ref", "ref", request.Ref, "error", err)
+			http.Error(w, "Unknown ref", http.StatusBadRequest)
+			return
+		}
+		job.Sha = sha
+		job.Pin = true
+	}
+
+	a.enqueue(w, job)
+}
+
+func (a *Api) handleServerAction(action deployment.Action) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		branch := mux.Vars(r)["branch"]
+		server := staging.NewServer(branch, nil)
+		if !server.Exists() {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		a.enqueue(w, deployment.Job{
+			Action:  action,
+			Branch:  branch,
+			Trigger: deployment.TriggerApi,
+		})
+	}
+}
+
+// handleRollbackServer rolls back to the release in the optional body, or to
+// the release before the current one.
+func (a *Api) handleRollbackServer(w http.ResponseWriter, r *http.Request) {
+	branch := mux.Vars(r)["branch"]
+	server := staging.NewServer(branch, nil)
+	if !server.Exists() {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var request struct {
+		Release         string `json:"release"`
+		RestoreDatabase bool   `json:"restore_database"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	a.enqueue(w, deployment.Job{
+		Action:          deployment.Rollback,
+		Branch:          branch,
+		Release:         request.Release,
+		RestoreDatabase: request.RestoreDatabase,
+		Trigger:         deployment.TriggerApi,
+	})
+}
+
+func (a *Api) handleReplayDelivery(w http.ResponseWriter, r *http.Request) {
+	if a.Webhooks == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	err := a.Webhooks.Replay(mux.Vars(r)["id"])
+	if os.IsNotExist(err) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		a.log(r).Error("Could not replay delivery", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (a *Api) enqueue(w http.ResponseWriter, job deployment.Job) {
+	a.Deployments.Enqueue(job)
+
+	server := staging.NewServer(job.Branch, nil)
+	serverJson, err := json.Marshal(&server)
+	if err != nil {
+		a.logger().Error("Could not encode server", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(serverJson)
+}
+
 func (a *Api) handleGetDiskSpace(w http.ResponseWriter, r *http.Request) {
-	size, used, err := getDiskSpaceInfo(staging.DefaultRootFolder)
+	size, used, err := DiskSpace(staging.DefaultRootFolder)
 	if err != nil {
-		fmt.Println(err.Error())
+		a.log(r).Error("Could not get disk space", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -63,34 +217,51 @@ func (a *Api) handleGetDiskSpace(w http.ResponseWriter, r *http.Request) {
 	}
 	diskSpaceJson, err := json.Marshal(diskSpaceInfo)
 	if err != nil {
-		fmt.Println(err.Error())
+		a.log(r).Error("Could not encode disk space", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	fmt.Println(diskSpaceJson)
-
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	w.Write(diskSpaceJson)
 }
 
-func getDiskSpaceInfo(dir string) (size int, used int, err error) {
-	c := exec.Command("sh", "-c", "df | grep /dev/vda1")
-	c.Dir = staging.DefaultRootFolder
-	output, err := c.Output()
-	if err != nil {
-		return 0, 0, err
+func (a *Api) logger() *slog.Logger {
+	if a.Logger == nil {
+		return slog.Default()
 	}
-	outputFields := strings.Fields(string(output[:]))
-	size, err = strconv.Atoi(outputFields[1])
+	return a.Logger
+}
+
+// log returns the logger of the request, with its request id.
+func (a *Api) log(r *http.Request) *slog.Logger {
+	return logging.FromContext(r.Context(), a.logger())
+}
+
+func listServers() ([]staging.Server, error) {
+	files, err := ioutil.ReadDir(staging.DefaultRootFolder)
 	if err != nil {
-		return 0, 0, err
+		return nil, err
 	}
-	used, err = strconv.Atoi(outputFields[2])
-	if err != nil {
+
+	var servers []staging.Server
+	for _, f := range files {
+		if f.IsDir() {
+			servers = append(servers, staging.NewServer(f.Name(), func(message string, progress int) {}))
+		}
+	}
+	return servers, nil
+}
+
+// DiskSpace returns the size and usage of the disk that holds dir in
+// kilobytes.
+func DiskSpace(dir string) (size int, used int, err error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
 		return 0, 0, err
 	}
-	fmt.Printf("space: %i %i\n", size, used)
-	return size, used, err
+	size = int(stat.Blocks * uint64(stat.Bsize) / 1024)
+	used = int((stat.Blocks - stat.Bfree) * uint64(stat.Bsize) / 1024)
+	return size, used, nil
 }
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/vektorprogrammet/build-system/auth"
+	"github.com/vektorprogrammet/build-system/deployment"
+	"github.com/vektorprogrammet/build-system/githubclient"
+)
+
+type testQueue struct {
+	jobs []deployment.Job
+}
+
+func (q *testQueue) Enqueue(job deployment.Job) {
+	q.jobs = append(q.jobs, job)
+}
+
+func newTestApi() (*Api, *testQueue) {
+	queue := &testQueue{}
+	api := &Api{
+		Router:      mux.NewRouter().PathPrefix("/api/").Subrouter(),
+		Auth:        auth.Tokens{"secret-token"},
+		Deployments: queue,
+	}
+	api.InitRoutes()
+	return api, queue
+}
+
+func TestApi_DeployRequiresToken(t *testing.T) {
+	api, queue := newTestApi()
+
+	for _, header := range []string{"", "Bearer wrong-token", "secret-token"} {
+		r := httptest.NewRequest("POST", "/api/servers", strings.NewReader(`{"branch":"master"}`))
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		api.Router.ServeHTTP(w, r)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d with Authorization %q, got %d", http.StatusUnauthorized, header, w.Code)
+		}
+	}
+
+	if len(queue.jobs) != 0 {
+		t.Errorf("Expected no jobs to be enqueued, got %d", len(queue.jobs))
+	}
+}
+
+func TestApi_Deploy(t *testing.T) {
+	router := http.NewServeMux()
+	router.HandleFunc("/repos/vektorprogrammet/vektorprogrammet/git/refs/heads/feature/login", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ref":"refs/heads/feature/login","object":{"sha":"abc123"}}`)
+	})
+	github := httptest.NewServer(router)
+	defer github.Close()
+	api, queue := newTestApi()
+	api.Github = &githubclient.TokenFactory{BaseURL: github.URL + "/"}
+
+	r := httptest.NewRequest("POST", "/api/servers", strings.NewReader(`{"branch":"feature/login"}`))
+	r.Header.Set("Authorization", "Bearer secret-token")
+	w := httptest.NewRecorder()
+	api.Router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status %d, got %d", http.StatusAccepted, w.Code)
+	}
+	if len(queue.jobs) != 1 {
+		t.Fatalf("Expected 1 job to be enqueued, got %d", len(queue.jobs))
+	}
+	if queue.jobs[0].Action != deployment.Deploy || queue.jobs[0].Branch != "feature/login" {
+		t.Errorf("Unexpected job %+v", queue.jobs[0])
+	}
+}
+
+func TestApi_DeployRef(t *testing.T) {
+	router := http.NewServeMux()
+	router.HandleFunc("/repos/vektorprogrammet/vektorprogrammet/git/refs/heads/feature/login", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ref":"refs/heads/feature/login","object":{"sha":"abc123"}}`)
+	})
+	router.HandleFunc("/repos/vektorprogrammet/vektorprogrammet/commits/v1.2", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "def456")
+	})
+	github := httptest.NewServer(router)
+	defer github.Close()
+	api, queue := newTestApi()
+	api.Github = &githubclient.TokenFactory{BaseURL: github.URL + "/"}
+
+	for ref, status := range map[string]int{"v1.2": http.StatusAccepted, "missing": http.StatusBadRequest, "$(id)": http.StatusBadRequest} {
+		r := httptest.NewRequest("POST", "/api/servers", strings.NewReader(fmt.Sprintf(`{"branch":"feature/login","ref":%q}`, ref)))
+		r.Header.Set("Authorization", "Bearer secret-token")
+		w := httptest.NewRecorder()
+		api.Router.ServeHTTP(w, r)
+
+		if w.Code != status {
+			t.Errorf("Expected status %d for %q, got %d", status, ref, w.Code)
+		}
+	}
+	if len(queue.jobs) != 1 || queue.jobs[0].Sha != "def456" || !queue.jobs[0].Pin {
+		t.Errorf("Expected a pinned deploy of the commit of the tag, got %+v", queue.jobs)
+	}
+}
+
+func TestApi_DeployRejectsUnknownBranches(t *testing.T) {
+	github := httptest.NewServer(http.NotFoundHandler())
+	defer github.Close()
+	api, queue := newTestApi()
+	api.Github = &githubclient.TokenFactory{BaseURL: github.URL + "/"}
+
+	for _, branch := range []string{"missing", "x;touch pwned", "$(id)"} {
+		r := httptest.NewRequest("POST", "/api/servers", strings.NewReader(fmt.Sprintf(`{"branch":%q}`, branch)))
+		r.Header.Set("Authorization", "Bearer secret-token")
+		w := httptest.NewRecorder()
+		api.Router.ServeHTTP(w, r)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %q, got %d", http.StatusBadRequest, branch, w.Code)
+		}
+	}
+	if len(queue.jobs) != 0 {
+		t.Errorf("Expected no jobs to be enqueued, got %+v", queue.jobs)
+	}
+}
+
+func TestApi_DeployWithoutBranch(t *testing.T) {
+	api, _ := newTestApi()
+
+	r := httptest.NewRequest("POST", "/api/servers", strings.NewReader(`{}`))
+	r.Header.Set("Authorization", "Bearer secret-token")
+	w := httptest.NewRecorder()
+	api.Router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestApi_RemoveUnknownServer(t *testing.T) {
+	api, queue := newTestApi()
+
+	r := httptest.NewRequest("DELETE", "/api/servers/does-not-exist", nil)
+	r.Header.Set("Authorization", "Bearer secret-token")
+	w := httptest.NewRecorder()
+	api.Router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+	if len(queue.jobs) != 0 {
+		t.Errorf("Expected no jobs to be enqueued, got %d", len(queue.jobs))
+	}
+}
+
+func TestApi_RollbackUnknownServer(t *testing.T) {
+	api, queue := newTestApi()
+
+	r := httptest.NewRequest("POST", "/api/servers/does-not-exist/rollback", strings.NewReader(`{"release":"abc123"}`))
+	r.Header.Set("Authorization", "Bearer secret-token")
+	w := httptest.NewRecorder()
+	api.Router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+	if len(queue.jobs) != 0 {
+		t.Errorf("Expected no jobs to be enqueued, got %d", len(queue.jobs))
+	}
+}
+
+type testAuthenticator auth.User
+
+func (a testAuthenticator) Authenticate(r *http.Request) (auth.User, bool) {
+	return auth.User(a), true
+}
+
+func TestApi_RemoveRequiresAdmin(t *testing.T) {
+	api, _ := newTestApi()
+	api.Router = mux.NewRouter().PathPrefix("/api/").Subrouter()
+	api.Auth = testAuthenticator{Login: "dev", Role: auth.Deployer}
+	api.InitRoutes()
+
+	r := httptest.NewRequest("DELETE", "/api/servers/master", nil)
+	w := httptest.NewRecorder()
+	api.Router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestDiskSpace(t *testing.T) {
+	size, used, err := DiskSpace(os.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size <= 0 || used < 0 || used > size {
+		t.Errorf("Expected the usage of the disk, got size %d and used %d", size, used)
+	}
+	if _, _, err := DiskSpace("/does/not/exist"); err == nil {
+		t.Errorf("Expected an error for a missing folder")
+	}
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"github.com/vektorprogrammet/build-system/deployment"
+	"github.com/vektorprogrammet/build-system/githubclient"
+	"github.com/vektorprogrammet/build-system/messenger"
+	"github.com/vektorprogrammet/build-system/staging"
+)
+
+const chatOpsPrefix = "/staging"
+
+var chatOpsActions = map[string]deployment.Action{
+	"deploy":   deployment.Deploy,
+	"redeploy": deployment.Redeploy,
+	"reset-db": deployment.ResetDatabase,
+	"destroy":  deployment.Remove,
+}
+
+var chatOpsHelp = "Available commands: `/staging deploy`, `/staging redeploy`, `/staging reset-db`, " +
+	"`/staging destroy`, `/staging logs`, `/staging pin` and `/staging unpin`"
+
+func parseChatOpsCommand(body string) (string, bool) {
+	for _, line := range strings.Split(body, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != chatOpsPrefix {
+			continue
+		}
+		if len(fields) == 1 {
+			return "help", true
+		}
+		return strings.ToLower(fields[1]), true
+	}
+
+	return "", false
+}
+
+func (wh *WebhookHandler) handleIssueCommentEvent(e *github.IssueCommentEvent) {
+	if e.GetAction() != "created" || e.GetIssue().PullRequestLinks == nil || e.GetComment().GetUser().GetType() == "Bot" {
+		return
+	}
+
+	command, ok := parseChatOpsCommand(e.GetComment().GetBody())
+	if !ok {
+		return
+	}
+
+	prNumber := e.GetIssue().GetNumber()
+	commenter := messenger.NewGithubCommenter(wh.Github, prNumber)
+	reply := func(message string) {
+		if _, err := commenter.Comment(message); err != nil {
+			wh.logger().Warn("Could not reply on pull request", "pull_request", prNumber, "error", err)
+		}
+	}
+
+	ctx := context.Background()
+	client, err := wh.Github.Client(ctx, githubclient.DefaultOwner, githubclient.DefaultRepo)
+	if err != nil {
+		wh.logger().Error("Could not create GitHub client", "error", err)
+		return
+	}
+
+	user := e.GetComment().GetUser().GetLogin()
+	permission, _, err := client.Repositories.GetPermissionLevel(ctx, githubclient.DefaultOwner, githubclient.DefaultRepo, user)
+	if err != nil {
+		wh.logger().Warn("Could not get permission level", "user", user, "error", err)
+		return
+	}
+	level := permission.GetPermission()
+	if level != "admin" && level != "write" && !(command == "logs" && level == "read") {
+		reply(fmt.Sprintf("@%s You need write access to the repository to run `/staging %s`", user, command))
+		return
+	}
+
+	pr, _, err := client.PullRequests.Get(ctx, githubclient.DefaultOwner, githubclient.DefaultRepo, prNumber)
+	if err != nil {
+		wh.logger().Warn("Could not get pull request", "pull_request", prNumber, "error", err)
+		return
+	}
+	job := pullRequestJob(pr, deployment.TriggerChatOps)
+	branch := job.Branch
+	server := staging.NewServer(branch, func(message string, progress int) {})
+
+	if action, ok := chatOpsActions[command]; ok {
+		job.Action = action
+		wh.Deployments.Enqueue(job)
+		return
+	}
+
+	switch command {
+	case "logs":
+		reply(chatOpsLogs(&server, wh.PublicUrl))
+	case "pin", "unpin":
+		if !server.Exists() {
+			reply(fmt.Sprintf("No staging server deployed for branch %s", branch))
+			return
+		}
+		if command == "pin" {
+			err = server.Pin()
+		} else {
+			err = server.Unpin()
+		}
+		if err != nil {
+			reply(fmt.Sprintf("`/staging %s` failed: %s", command, err))
+			return
+		}
+		if command == "pin" {
+			reply("Staging server pinned. It will not be updated by new commits until `/staging unpin`")
+		} else {
+			reply("Staging server unpinned. It will be updated by new commits")
+		}
+	default:
+		reply(chatOpsHelp)
+	}
+}
+
+// chatOpsLogs links to the deploy log instead of posting it, since the output
+// of composer, npm and the console is not meant for everyone who can read the
+// pull request.
+func chatOpsLogs(server *staging.Server, publicUrl string) string {
+	if _, err := os.Stat(server.LogFile()); err != nil {
+		return fmt.Sprintf("No deploy log found for branch %s", server.Branch)
+	}
+
+	path := fmt.Sprintf("/api/servers/%s/logs", server.Branch)
+	if publicUrl == "" {
+		return fmt.Sprintf("The deploy log of %s is available to signed in users at `GET %s`", server.Branch, path)
+	}
+	return fmt.Sprintf("[Deploy log of %s](%s%s) (requires signing in)", server.Branch, publicUrl, path)
+}
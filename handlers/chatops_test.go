@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+	"github.com/vektorprogrammet/build-system/deployment"
+	"github.com/vektorprogrammet/build-system/githubclient"
+)
+
+func TestParseChatOpsCommand(t *testing.T) {
+	tests := []struct {
+		body     string
+		command  string
+		expected bool
+	}{
+		{"/staging deploy", "deploy", true},
+		{"Looks good!\r\n/staging  Redeploy please", "redeploy", true},
+		{"/staging", "help", true},
+		{"Run `/staging deploy` to deploy", "", false},
+		{"/stagingdeploy", "", false},
+	}
+
+	for _, test := range tests {
+		command, ok := parseChatOpsCommand(test.body)
+		if command != test.command || ok != test.expected {
+			t.Errorf("%q: Expected (%q, %t), got (%q, %t)", test.body, test.command, test.expected, command, ok)
+		}
+	}
+}
+
+func newFakeChatOpsGitHub(permission string, comments *[]string) *httptest.Server {
+	router := http.NewServeMux()
+	router.HandleFunc("/repos/vektorprogrammet/vektorprogrammet/collaborators/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"permission":%q}`, permission)
+	})
+	router.HandleFunc("/repos/vektorprogrammet/vektorprogrammet/pulls/12", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"number":12,"head":{"ref":"feature/chatops","sha":"abc123"}}`)
+	})
+	router.HandleFunc("/repos/vektorprogrammet/vektorprogrammet/issues/12/comments", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var comment github.IssueComment
+		json.Unmarshal(body, &comment)
+		*comments = append(*comments, comment.GetBody())
+		fmt.Fprint(w, `{"id":1}`)
+	})
+	return httptest.NewServer(router)
+}
+
+func newChatOpsEvent(body string) *github.IssueCommentEvent {
+	return &github.IssueCommentEvent{
+		Action: github.String("created"),
+		Issue: &github.Issue{
+			Number:           github.Int(12),
+			PullRequestLinks: &github.PullRequestLinks{},
+		},
+		Comment: &github.IssueComment{
+			Body: github.String(body),
+			User: &github.User{Login: github.String("reviewer"), Type: github.String("User")},
+		},
+	}
+}
+
+func TestWebhookHandler_ChatOpsDeploy(t *testing.T) {
+	var comments []string
+	server := newFakeChatOpsGitHub("write", &comments)
+	defer server.Close()
+
+	queue := &testQueue{}
+	wh := WebhookHandler{
+		Deployments: queue,
+		Github:      &githubclient.TokenFactory{BaseURL: server.URL + "/"},
+	}
+	wh.handleIssueCommentEvent(newChatOpsEvent("/staging redeploy"))
+
+	if len(queue.jobs) != 1 {
+		t.Fatalf("Expected 1 job to be enqueued, got %d", len(queue.jobs))
+	}
+	job := queue.jobs[0]
+	if job.Action != deployment.Redeploy || job.Branch != "feature/chatops" || job.Sha != "abc123" || job.PrNumber != 12 {
+		t.Errorf("Unexpected job %+v", job)
+	}
+}
+
+func TestWebhookHandler_ChatOpsRequiresWriteAccess(t *testing.T) {
+	var comments []string
+	server := newFakeChatOpsGitHub("read", &comments)
+	defer server.Close()
+
+	queue := &testQueue{}
+	wh := WebhookHandler{
+		Deployments: queue,
+		Github:      &githubclient.TokenFactory{BaseURL: server.URL + "/"},
+	}
+	wh.handleIssueCommentEvent(newChatOpsEvent("/staging destroy"))
+
+	if len(queue.jobs) != 0 {
+		t.Errorf("Expected no jobs to be enqueued, got %d", len(queue.jobs))
+	}
+	if len(comments) != 1 || !strings.Contains(comments[0], "write access") {
+		t.Errorf("Expected a reply about missing write access, got %q", comments)
+	}
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+var deliveryIdPattern = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+// Delivery is a webhook payload as it was received from GitHub.
+type Delivery struct {
+	Id      string          `json:"id"`
+	Event   string          `json:"event"`
+	Time    time.Time       `json:"time"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// DeliveryStore keeps received webhook deliveries on disk, so retried
+// deliveries can be ignored and old ones replayed.
+type DeliveryStore struct {
+	Folder string
+}
+
+func NewDeliveryStore(folder string) (*DeliveryStore, error) {
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		return nil, err
+	}
+	return &DeliveryStore{Folder: folder}, nil
+}
+
+// Record stores a delivery. It returns false if the delivery has been
+// received before.
+func (s *DeliveryStore) Record(d Delivery) (bool, error) {
+	path, err := s.path(d.Id)
+	if err != nil {
+		return false, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	return true, json.NewEncoder(file).Encode(d)
+}
+
+func (s *DeliveryStore) Get(id string) (Delivery, error) {
+	var d Delivery
+	path, err := s.path(id)
+	if err != nil {
+		return d, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return d, err
+	}
+	err = json.Unmarshal(data, &d)
+	return d, err
+}
+
+// Prune removes deliveries older than maxAge.
+func (s *DeliveryStore) Prune(maxAge time.Duration) error {
+	files, err := ioutil.ReadDir(s.Folder)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if time.Since(file.ModTime()) > maxAge {
+			if err := os.Remove(filepath.Join(s.Folder, file.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *DeliveryStore) path(id string) (string, error) {
+	if !deliveryIdPattern.MatchString(id) {
+		return "", fmt.Errorf("invalid delivery id %q", id)
+	}
+	return filepath.Join(s.Folder, id+".json"), nil
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/vektorprogrammet/build-system/deployment"
+)
+
+const pushPayload = `{"ref":"refs/heads/feature","after":"6dcb09b5b57875f334f61aebed695e2e4193db5e"}`
+
+func newTestDeliveryStore(t *testing.T) *DeliveryStore {
+	folder, err := ioutil.TempDir("", "deliveries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &DeliveryStore{Folder: folder}
+}
+
+func TestDeliveryStore_RecordDeduplicates(t *testing.T) {
+	store := newTestDeliveryStore(t)
+	defer os.RemoveAll(store.Folder)
+
+	delivery := Delivery{Id: "72d3162e-cc78-11e3-81ab-4c9367dc0958", Event: "push", Time: time.Now(), Payload: []byte(pushPayload)}
+	if isNew, err := store.Record(delivery); !isNew || err != nil {
+		t.Fatalf("Expected first delivery to be recorded, got %v, %v", isNew, err)
+	}
+	if isNew, err := store.Record(delivery); isNew || err != nil {
+		t.Errorf("Expected retried delivery to be a duplicate, got %v, %v", isNew, err)
+	}
+
+	stored, err := store.Get(delivery.Id)
+	if err != nil || stored.Event != "push" || string(stored.Payload) != pushPayload {
+		t.Errorf("Unexpected stored delivery %+v, %v", stored, err)
+	}
+}
+
+func TestDeliveryStore_RejectsInvalidIds(t *testing.T) {
+	store := newTestDeliveryStore(t)
+	defer os.RemoveAll(store.Folder)
+
+	if _, err := store.Get("../../etc/passwd"); err == nil {
+		t.Error("Expected error for invalid delivery id")
+	}
+}
+
+func TestApi_ReplayDelivery(t *testing.T) {
+	api, queue := newTestApi()
+	store := newTestDeliveryStore(t)
+	defer os.RemoveAll(store.Folder)
+	api.Webhooks = &WebhookHandler{Deployments: queue, Deliveries: store}
+	store.Record(Delivery{Id: "72d3162e-cc78-11e3-81ab-4c9367dc0958", Event: "push", Payload: []byte(pushPayload)})
+
+	for id, expected := range map[string]int{
+		"72d3162e-cc78-11e3-81ab-4c9367dc0958": http.StatusAccepted,
+		"unknown":                              http.StatusNotFound,
+	} {
+		r := httptest.NewRequest("POST", "/api/deliveries/"+id+"/replay", nil)
+		r.Header.Set("Authorization", "Bearer secret-token")
+		w := httptest.NewRecorder()
+		api.Router.ServeHTTP(w, r)
+
+		if w.Code != expected {
+			t.Errorf("Expected status %d when replaying %s, got %d", expected, id, w.Code)
+		}
+	}
+
+	if len(queue.jobs) != 1 || queue.jobs[0].Action != deployment.Update || queue.jobs[0].Branch != "feature" {
+		t.Errorf("Expected the push to be replayed, got %+v", queue.jobs)
+	}
+}
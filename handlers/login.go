@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/vektorprogrammet/build-system/auth"
+	"github.com/vektorprogrammet/build-system/logging"
+)
+
+const oauthStateCookieName = "staging_oauth_state"
+
+type Login struct {
+	Router      *mux.Router
+	GitHub      *auth.GitHub
+	Sessions    *auth.Sessions
+	RedirectUrl string
+}
+
+func (l *Login) InitRoutes() {
+	l.Router.HandleFunc("/login", l.handleLogin).Methods("GET")
+	l.Router.HandleFunc("/callback", l.handleCallback).Methods("GET")
+	l.Router.HandleFunc("/logout", l.handleLogout).Methods("POST")
+	l.Router.HandleFunc("/user", l.handleGetUser).Methods("GET")
+}
+
+func (l *Login) handleLogin(w http.ResponseWriter, r *http.Request) {
+	state := make([]byte, 16)
+	if _, err := rand.Read(state); err != nil {
+		logging.FromContext(r.Context(), nil).Error("Could not create OAuth state", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	encodedState := base64.RawURLEncoding.EncodeToString(state)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookieName,
+		Value:    encodedState,
+		Path:     "/",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, l.GitHub.AuthCodeURL(encodedState), http.StatusFound)
+}
+
+func (l *Login) handleCallback(w http.ResponseWriter, r *http.Request) {
+	stateCookie, err := r.Cookie(oauthStateCookieName)
+	if err != nil || stateCookie.Value == "" || stateCookie.Value != r.URL.Query().Get("state") {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	user, err := l.GitHub.Login(r.Context(), r.URL.Query().Get("code"))
+	if err == auth.ErrNotMember {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context(), nil).Warn("GitHub login failed", "error", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if err := l.Sessions.Set(w, user); err != nil {
+		logging.FromContext(r.Context(), nil).Error("Could not create session", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	redirectUrl := l.RedirectUrl
+	if redirectUrl == "" {
+		redirectUrl = "/"
+	}
+	http.Redirect(w, r, redirectUrl, http.StatusFound)
+}
+
+func (l *Login) handleLogout(w http.ResponseWriter, r *http.Request) {
+	l.Sessions.Clear(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (l *Login) handleGetUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := l.Sessions.Authenticate(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	userJson, err := json.Marshal(struct {
+		Login string `json:"login"`
+		Role  string `json:"role"`
+	}{user.Login, user.Role.String()})
+	if err != nil {
+		logging.FromContext(r.Context(), nil).Error("Could not encode user", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(userJson)
+}
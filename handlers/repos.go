@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/vektorprogrammet/build-system/git"
+	"github.com/vektorprogrammet/build-system/githubclient"
+)
+
+// Repo configures which webhook events deploy staging servers for a
+// repository.
+type Repo struct {
+	Name string `json:"name"`
+	// AutoDeployBranches are patterns of new branches that are deployed as
+	// soon as they are created, like "release/*".
+	AutoDeployBranches []string `json:"auto_deploy_branches"`
+	// RequireLabel only deploys pull requests with this label when set.
+	RequireLabel string `json:"require_label"`
+	DeployDrafts bool   `json:"deploy_drafts"`
+	// ApprovalLabel lets maintainers deploy pull requests from forks.
+	ApprovalLabel string `json:"approval_label"`
+}
+
+const defaultApprovalLabel = "safe-to-deploy"
+
+func (r Repo) approvalLabel() string {
+	if r.ApprovalLabel == "" {
+		return defaultApprovalLabel
+	}
+	return r.ApprovalLabel
+}
+
+func (r Repo) autoDeploys(branch string) bool {
+	for _, pattern := range r.AutoDeployBranches {
+		if matched, _ := path.Match(pattern, branch); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadRepos reads the repository rules from a JSON file. A missing file
+// means no rules.
+func LoadRepos(path string) ([]Repo, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var repos []Repo
+	err = json.Unmarshal(data, &repos)
+	return repos, err
+}
+
+// CheckRepos fails for repositories other than the default repository.
+// Staging servers are named after branches and always deployed from the
+// default repository, so events from another repository would deploy or
+// remove the server of a branch with the same name.
+func CheckRepos(repos []Repo) error {
+	for _, repo := range repos {
+		if !isDefaultRepo(repo.Name) {
+			return fmt.Errorf("staging servers can only be deployed from %s, not %s", defaultRepoName, repo.Name)
+		}
+	}
+	return nil
+}
+
+const defaultRepoName = githubclient.DefaultOwner + "/" + githubclient.DefaultRepo
+
+func isDefaultRepo(fullName string) bool {
+	return strings.EqualFold(fullName, defaultRepoName)
+}
+
+func (wh *WebhookHandler) repo(fullName string) (Repo, bool) {
+	if !isDefaultRepo(fullName) {
+		return Repo{}, false
+	}
+	for _, repo := range wh.Repos {
+		if strings.EqualFold(repo.Name, fullName) {
+			return repo, true
+		}
+	}
+	return Repo{}, false
+}
+
+// ensureBranchExists checks that a branch requested by a user is a safe branch
+// name and exists in the default repository.
+func ensureBranchExists(github githubclient.Factory, branch string) error {
+	if err := git.CheckBranchName(branch); err != nil {
+		return err
+	}
+	if github == nil {
+		return errors.New("GitHub is not configured")
+	}
+
+	ctx := context.Background()
+	client, err := github.Client(ctx, githubclient.DefaultOwner, githubclient.DefaultRepo)
+	if err != nil {
+		return err
+	}
+	_, _, err = client.Git.GetRef(ctx, githubclient.DefaultOwner, githubclient.DefaultRepo, "refs/heads/"+branch)
+	return err
+}
+
+// commitSha looks up the commit of a SHA, tag or branch in the default
+// repository.
+func commitSha(github githubclient.Factory, ref string) (string, error) {
+	if err := git.CheckBranchName(ref); err != nil {
+		return "", err
+	}
+	if github == nil {
+		return "", errors.New("GitHub is not configured")
+	}
+
+	ctx := context.Background()
+	client, err := github.Client(ctx, githubclient.DefaultOwner, githubclient.DefaultRepo)
+	if err != nil {
+		return "", err
+	}
+	sha, _, err := client.Repositories.GetCommitSHA1(ctx, githubclient.DefaultOwner, githubclient.DefaultRepo, ref, "")
+	return sha, err
+}
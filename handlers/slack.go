@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/vektorprogrammet/build-system/auth"
+	"github.com/vektorprogrammet/build-system/deployment"
+	"github.com/vektorprogrammet/build-system/githubclient"
+	"github.com/vektorprogrammet/build-system/logging"
+	"github.com/vektorprogrammet/build-system/staging"
+)
+
+const slackRequestMaxAge = 5 * time.Minute
+
+const maxSlackRequestSize = 64 << 10
+
+var slackClient = &http.Client{Timeout: 10 * time.Second}
+
+type SlackHandler struct {
+	SigningSecret []byte
+	Router        *mux.Router
+	Deployments   deployment.Queue
+	Github        githubclient.Factory
+	// Roles of Slack user ids, like the roles of the API. Anyone in the
+	// workspace can list servers and show their status, deployers can
+	// deploy and redeploy, and admins can also destroy servers.
+	Roles map[string]auth.Role
+	// Logger defaults to slog.Default.
+	Logger *slog.Logger
+}
+
+type slackResponse struct {
+	ResponseType    string `json:"response_type"`
+	Text            string `json:"text"`
+	ReplaceOriginal bool   `json:"replace_original"`
+}
+
+type slackInteraction struct {
+	Type string `json:"type"`
+	User struct {
+		Id       string `json:"id"`
+		Username string `json:"username"`
+	} `json:"user"`
+	Actions []struct {
+		ActionId string `json:"action_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+	ResponseUrl string `json:"response_url"`
+}
+
+func (sh *SlackHandler) InitRoutes() {
+	sh.Router.HandleFunc("/slack", sh.handleSlack).Methods("POST")
+}
+
+func (sh *SlackHandler) handleSlack(w http.ResponseWriter, r *http.Request) {
+	log := sh.log(r)
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxSlackRequestSize))
+	if err != nil {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	err = verifySlackSignature(sh.SigningSecret, r.Header.Get("X-Slack-Request-Timestamp"), r.Header.Get("X-Slack-Signature"), body, time.Now())
+	if err != nil {
+		log.Warn("Invalid Slack request", "error", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if payload := form.Get("payload"); payload != "" {
+		sh.handleInteraction(w, log, payload)
+		return
+	}
+	sh.handleCommand(w, log, form)
+}
+
+func (sh *SlackHandler) handleCommand(w http.ResponseWriter, log *slog.Logger, form url.Values) {
+	args := strings.Fields(form.Get("text"))
+	if len(args) == 0 {
+		writeSlackResponse(w, log, "ephemeral", "Usage: `/staging list`, `/staging deploy <branch>`, `/staging destroy <branch>` or `/staging status <branch>`")
+		return
+	}
+
+	command := strings.ToLower(args[0])
+	if command == "list" {
+		writeSlackResponse(w, log, "ephemeral", slackServerList())
+		return
+	}
+	if len(args) < 2 {
+		writeSlackResponse(w, log, "ephemeral", fmt.Sprintf("Usage: `/staging %s <branch>`", command))
+		return
+	}
+
+	branch := args[1]
+	server := staging.NewServer(branch, nil)
+	if required := slackCommandRoles[command]; sh.Roles[form.Get("user_id")] < required {
+		writeSlackResponse(w, log, "ephemeral", fmt.Sprintf("You need the %s role to %s servers", required, command))
+		return
+	}
+	switch command {
+	case "status":
+		writeSlackResponse(w, log, "ephemeral", slackServerStatus(&server))
+	case "deploy":
+		if err := ensureBranchExists(sh.Github, branch); err != nil {
+			writeSlackResponse(w, log, "ephemeral", fmt.Sprintf("Could not find branch %s", branch))
+			return
+		}
+		sh.Deployments.Enqueue(deployment.Job{
+			Action:  deployment.Deploy,
+			Branch:  branch,
+			Trigger: deployment.TriggerSlack,
+		})
+		writeSlackResponse(w, log, "in_channel", fmt.Sprintf("%s requested a deploy of %s", form.Get("user_name"), branch))
+	case "destroy":
+		if !server.Exists() {
+			writeSlackResponse(w, log, "ephemeral", fmt.Sprintf("No staging server deployed for branch %s", branch))
+			return
+		}
+		sh.Deployments.Enqueue(deployment.Job{
+			Action:  deployment.Remove,
+			Branch:  branch,
+			Trigger: deployment.TriggerSlack,
+		})
+		writeSlackResponse(w, log, "in_channel", fmt.Sprintf("%s requested removal of %s", form.Get("user_name"), branch))
+	default:
+		writeSlackResponse(w, log, "ephemeral", fmt.Sprintf("Unrecognized command %s", command))
+	}
+}
+
+func (sh *SlackHandler) handleInteraction(w http.ResponseWriter, log *slog.Logger, payload string) {
+	var interaction slackInteraction
+	if err := json.Unmarshal([]byte(payload), &interaction); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+
+	for _, action := range interaction.Actions {
+		jobAction := deployment.Action(action.ActionId)
+		if jobAction != deployment.Redeploy && jobAction != deployment.Remove {
+			continue
+		}
+		if required := slackActionRoles[jobAction]; sh.Roles[interaction.User.Id] < required {
+			respondToSlack(log, interaction.ResponseUrl, fmt.Sprintf("You need the %s role to %s servers", required, jobAction))
+			continue
+		}
+
+		server := staging.NewServer(action.Value, nil)
+		if !server.Exists() {
+			respondToSlack(log, interaction.ResponseUrl, fmt.Sprintf("No staging server deployed for branch %s", action.Value))
+			continue
+		}
+
+		sh.Deployments.Enqueue(deployment.Job{
+			Action:  jobAction,
+			Branch:  action.Value,
+			Trigger: deployment.TriggerSlack,
+		})
+		respondToSlack(log, interaction.ResponseUrl, fmt.Sprintf("%s requested %s of %s", interaction.User.Username, jobAction, action.Value))
+	}
+}
+
+var slackCommandRoles = map[string]auth.Role{
+	"deploy":  auth.Deployer,
+	"destroy": auth.Admin,
+}
+
+var slackActionRoles = map[deployment.Action]auth.Role{
+	deployment.Redeploy: auth.Deployer,
+	deployment.Remove:   auth.Admin,
+}
+
+func verifySlackSignature(secret []byte, timestamp, signature string, body []byte, now time.Time) error {
+	if len(secret) == 0 {
+		return errors.New("no signing secret configured")
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid timestamp")
+	}
+	if math.Abs(now.Sub(time.Unix(seconds, 0)).Seconds()) > slackRequestMaxAge.Seconds() {
+		return errors.New("request is too old")
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errors.New("signature mismatch")
+	}
+
+	return nil
+}
+
+func slackServerList() string {
+	servers, err := listServers()
+	if err != nil {
+		return fmt.Sprintf("Could not list servers: %s", err)
+	}
+	if len(servers) == 0 {
+		return "No staging servers deployed"
+	}
+
+	var lines []string
+	for _, server := range servers {
+		lines = append(lines, fmt.Sprintf("• %s: https://%s", server.Branch, server.ServerName()))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func slackServerStatus(server *staging.Server) string {
+	if !server.Exists() {
+		return fmt.Sprintf("No staging server deployed for branch %s", server.Branch)
+	}
+
+	status := fmt.Sprintf("%s is deployed at https://%s", server.Branch, server.ServerName())
+	if server.IsPinned() {
+		status += " (pinned)"
+	}
+	return status
+}
+
+func writeSlackResponse(w http.ResponseWriter, log *slog.Logger, responseType, text string) {
+	responseJson, err := json.Marshal(slackResponse{ResponseType: responseType, Text: text})
+	if err != nil {
+		log.Error("Could not encode Slack response", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseJson)
+}
+
+func respondToSlack(log *slog.Logger, responseUrl, text string) {
+	if responseUrl == "" {
+		return
+	}
+
+	responseJson, _ := json.Marshal(slackResponse{ResponseType: "ephemeral", Text: text})
+	resp, err := slackClient.Post(responseUrl, "application/json", bytes.NewBuffer(responseJson))
+	if err != nil {
+		log.Warn("Could not respond to Slack", "error", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+func (sh *SlackHandler) logger() *slog.Logger {
+	if sh.Logger == nil {
+		return slog.Default()
+	}
+	return sh.Logger
+}
+
+// log returns the logger of the request, with its request id.
+func (sh *SlackHandler) log(r *http.Request) *slog.Logger {
+	return logging.FromContext(r.Context(), sh.logger())
+}
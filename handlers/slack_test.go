@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/vektorprogrammet/build-system/auth"
+	"github.com/vektorprogrammet/build-system/deployment"
+	"github.com/vektorprogrammet/build-system/githubclient"
+)
+
+func signSlackRequest(r *http.Request, secret, body string, timestamp time.Time) {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":" + body))
+	r.Header.Set("X-Slack-Request-Timestamp", ts)
+	r.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+}
+
+func TestVerifySlackSignature(t *testing.T) {
+	now := time.Now()
+	body := "command=%2Fstaging&text=list"
+	r := httptest.NewRequest("POST", "/webhooks/slack", nil)
+	signSlackRequest(r, "signing-secret", body, now)
+	ts, signature := r.Header.Get("X-Slack-Request-Timestamp"), r.Header.Get("X-Slack-Signature")
+
+	if err := verifySlackSignature([]byte("signing-secret"), ts, signature, []byte(body), now); err != nil {
+		t.Errorf("Expected valid signature, got %s", err)
+	}
+	if err := verifySlackSignature([]byte("another-secret"), ts, signature, []byte(body), now); err == nil {
+		t.Error("Expected signature with another secret to be rejected")
+	}
+	if err := verifySlackSignature([]byte("signing-secret"), ts, signature, []byte(body+"x"), now); err == nil {
+		t.Error("Expected modified body to be rejected")
+	}
+	if err := verifySlackSignature([]byte("signing-secret"), ts, signature, []byte(body), now.Add(10*time.Minute)); err == nil {
+		t.Error("Expected old request to be rejected")
+	}
+	if err := verifySlackSignature(nil, ts, signature, []byte(body), now); err == nil {
+		t.Error("Expected request to be rejected without a signing secret")
+	}
+}
+
+func newTestSlackHandler(githubUrl string) (*SlackHandler, *testQueue) {
+	queue := &testQueue{}
+	sh := &SlackHandler{
+		SigningSecret: []byte("signing-secret"),
+		Router:        mux.NewRouter().PathPrefix("/webhooks/").Subrouter(),
+		Deployments:   queue,
+		Github:        &githubclient.TokenFactory{BaseURL: githubUrl + "/"},
+		Roles:         map[string]auth.Role{"U-deployer": auth.Deployer},
+	}
+	sh.InitRoutes()
+	return sh, queue
+}
+
+func TestSlackHandler_DeployCommand(t *testing.T) {
+	router := http.NewServeMux()
+	router.HandleFunc("/repos/vektorprogrammet/vektorprogrammet/git/refs/heads/feature/slack", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ref":"refs/heads/feature/slack","object":{"sha":"abc123"}}`)
+	})
+	github := httptest.NewServer(router)
+	defer github.Close()
+	sh, queue := newTestSlackHandler(github.URL)
+
+	body := url.Values{"command": {"/staging"}, "text": {"deploy feature/slack"}, "user_name": {"reviewer"}, "user_id": {"U-deployer"}}.Encode()
+	r := httptest.NewRequest("POST", "/webhooks/slack", strings.NewReader(body))
+	signSlackRequest(r, "signing-secret", body, time.Now())
+	w := httptest.NewRecorder()
+	sh.Router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if len(queue.jobs) != 1 || queue.jobs[0].Action != deployment.Deploy || queue.jobs[0].Branch != "feature/slack" {
+		t.Errorf("Expected deploy of feature/slack to be enqueued, got %+v", queue.jobs)
+	}
+}
+
+func TestSlackHandler_RejectsUnsignedRequests(t *testing.T) {
+	sh, queue := newTestSlackHandler("http://localhost")
+
+	body := url.Values{"command": {"/staging"}, "text": {"destroy master"}}.Encode()
+	r := httptest.NewRequest("POST", "/webhooks/slack", strings.NewReader(body))
+	signSlackRequest(r, "another-secret", body, time.Now())
+	w := httptest.NewRecorder()
+	sh.Router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+	if len(queue.jobs) != 0 {
+		t.Errorf("Expected no jobs to be enqueued, got %d", len(queue.jobs))
+	}
+}
+
+func TestSlackHandler_RequiresRoles(t *testing.T) {
+	sh, queue := newTestSlackHandler("http://localhost")
+
+	for _, command := range []url.Values{
+		{"command": {"/staging"}, "text": {"deploy feature"}, "user_id": {"U-anyone"}},
+		{"command": {"/staging"}, "text": {"destroy feature"}, "user_id": {"U-deployer"}},
+		{"payload": {`{"type":"block_actions","user":{"id":"U-anyone"},"actions":[{"action_id":"redeploy","value":"feature"}]}`}},
+		{"payload": {`{"type":"block_actions","user":{"id":"U-deployer"},"actions":[{"action_id":"remove","value":"feature"}]}`}},
+	} {
+		body := command.Encode()
+		r := httptest.NewRequest("POST", "/webhooks/slack", strings.NewReader(body))
+		signSlackRequest(r, "signing-secret", body, time.Now())
+		w := httptest.NewRecorder()
+		sh.Router.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %d for %v, got %d", http.StatusOK, command, w.Code)
+		}
+		if command.Get("text") != "" && !strings.Contains(w.Body.String(), "role") {
+			t.Errorf("Expected %v to be refused, got %s", command, w.Body.String())
+		}
+	}
+	if len(queue.jobs) != 0 {
+		t.Errorf("Expected no jobs to be enqueued, got %+v", queue.jobs)
+	}
+}
+
+func TestSlackHandler_RejectsLargeRequests(t *testing.T) {
+	sh, _ := newTestSlackHandler("http://localhost")
+
+	body := url.Values{"command": {"/staging"}, "text": {strings.Repeat("x", maxSlackRequestSize)}}.Encode()
+	r := httptest.NewRequest("POST", "/webhooks/slack", strings.NewReader(body))
+	signSlackRequest(r, "signing-secret", body, time.Now())
+	w := httptest.NewRecorder()
+	sh.Router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %d, got %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+}
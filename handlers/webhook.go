@@ -1,146 +1,372 @@
 package handlers
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/google/go-github/github"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/vektorprogrammet/build-system/deployment"
+	"github.com/vektorprogrammet/build-system/githubclient"
+	"github.com/vektorprogrammet/build-system/logging"
 	"github.com/vektorprogrammet/build-system/messenger"
-	"github.com/vektorprogrammet/build-system/staging"
+	"github.com/vektorprogrammet/build-system/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var eventChan chan interface{}
+var eventChan chan webhookEvent
 
-type WebhookHandler struct{
-	Secret []byte
-	Router *mux.Router
-	Messenger messenger.Messenger
+const maxWebhookSize = 5 << 20
+
+var webhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "staging_webhook_deliveries_total",
+	Help: "GitHub webhook deliveries with a valid signature.",
+}, []string{"event"})
+
+var webhookEvents = map[string]bool{
+	"ping":          true,
+	"push":          true,
+	"create":        true,
+	"delete":        true,
+	"pull_request":  true,
+	"issue_comment": true,
+}
+
+type WebhookHandler struct {
+	Secret      []byte
+	Router      *mux.Router
+	Repos       []Repo
+	Deployments deployment.Queue
+	Github      githubclient.Factory
+	Deliveries  *DeliveryStore
+	// PublicUrl is used to link to deploy logs.
+	PublicUrl string
+	// Logger defaults to slog.Default.
+	Logger *slog.Logger
 }
 
 func (wh *WebhookHandler) InitRoutes() {
-	wh.Router.HandleFunc("/github", wh.handleWebhook)
+	wh.Router.HandleFunc("/github", wh.handleWebhook).Methods("POST")
 	wh.startGitHubEventListeners()
 }
 
 func (wh *WebhookHandler) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	logger := logging.FromContext(r.Context(), wh.logger())
+	if len(wh.Secret) == 0 {
+		http.Error(w, "Webhook secret is not configured", http.StatusUnauthorized)
+		return
+	}
+
+	eventType := github.WebHookType(r)
+	id := r.Header.Get("X-GitHub-Delivery")
+	ctx, span := tracing.Start(r.Context(), "github webhook", trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+		attribute.String("event", eventType),
+		attribute.String("delivery.id", id),
+	))
+	defer span.End()
+	if !webhookEvents[eventType] {
+		http.Error(w, fmt.Sprintf("Unsupported event %q", eventType), http.StatusBadRequest)
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxWebhookSize+1))
+	if err != nil {
+		http.Error(w, "Could not read request body", http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxWebhookSize {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	_, validation := tracing.Start(ctx, "validate signature")
 	payload, err := github.ValidatePayload(r, wh.Secret)
+	tracing.End(validation, err)
 	if err != nil {
-		fmt.Printf("Failed to validate payload: %s\n", err)
-		wh.Messenger.Send(fmt.Sprintf("Failed to validate payload: %s\n", err))
+		logger.Warn("Invalid webhook signature", "error", err)
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
 		return
 	}
-	event, err := github.ParseWebHook(github.WebHookType(r), payload)
+	webhookDeliveries.WithLabelValues(eventType).Inc()
+	event, err := github.ParseWebHook(eventType, payload)
 	if err != nil {
-		fmt.Printf("Failed to parse webhook: %s\n", err)
-		wh.Messenger.Send(fmt.Sprintf("Failed to parse webhook: %s\n", err))
+		logger.Warn("Could not parse webhook", "event", eventType, "error", err)
+		http.Error(w, "Could not parse payload", http.StatusBadRequest)
 		return
 	}
-	go func(event interface{}) {
+
+	if _, ok := event.(*github.PingEvent); ok {
+		logger.Info("Received ping", "hook_id", r.Header.Get("X-GitHub-Hook-ID"))
+		w.Write([]byte("pong"))
+		return
+	}
+
+	var repository struct {
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+	}
+	json.Unmarshal(payload, &repository)
+	if _, ok := wh.repo(repository.Repository.FullName); !ok {
+		logger.Info("Ignoring event from unknown repository", "event", eventType, "repo", repository.Repository.FullName)
+		http.Error(w, fmt.Sprintf("Repository %q is not configured", repository.Repository.FullName), http.StatusBadRequest)
+		return
+	}
+
+	if wh.Deliveries != nil && id != "" {
+		isNew, err := wh.Deliveries.Record(Delivery{Id: id, Event: eventType, Time: time.Now(), Payload: payload})
+		if err != nil {
+			logger.Error("Could not store delivery", "error", err)
+		} else if !isNew {
+			logger.Info("Ignoring duplicate delivery")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+
+	go func(event webhookEvent) {
 		eventChan <- event
-	}(event)
+	}(webhookEvent{Type: eventType, Event: event, Payload: payload, DeliveryId: id, Trace: span.SpanContext()})
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Replay handles a stored delivery again.
+func (wh *WebhookHandler) Replay(id string) error {
+	if wh.Deliveries == nil {
+		return fmt.Errorf("deliveries are not stored")
+	}
+
+	delivery, err := wh.Deliveries.Get(id)
+	if err != nil {
+		return err
+	}
+	event, err := github.ParseWebHook(delivery.Event, delivery.Payload)
+	if err != nil {
+		return err
+	}
+
+	wh.logger().Info("Replaying delivery", "delivery_id", delivery.Id, "event", delivery.Event)
+	wh.handleEvent(webhookEvent{Type: delivery.Event, Event: event, Payload: delivery.Payload, DeliveryId: delivery.Id})
+	return nil
 }
 
 func (wh *WebhookHandler) startGitHubEventListeners() {
-	eventChan = make(chan interface{})
+	eventChan = make(chan webhookEvent)
 	go func() {
 		for event := range eventChan {
-			wh.handlePullRequestEvent(event)
-			wh.handleBranchDeleteEvent(event)
-			wh.handlePushEvent(event)
+			wh.handleEvent(event)
 		}
 	}()
 }
 
-func (wh *WebhookHandler) handlePushEvent(event interface{}) {
-	e, ok := event.(*github.PushEvent)
-	if !ok {
-		fmt.Println("Not a push event")
-		return
+// webhookEvent is a parsed delivery. The raw payload is kept for fields
+// go-github does not know about.
+type webhookEvent struct {
+	Type       string
+	Event      interface{}
+	Payload    []byte
+	DeliveryId string
+	// Trace is the span of the request that delivered the event.
+	Trace trace.SpanContext
+}
+
+// handleEvent handles an event with a copy of the handler that adds the
+// delivery to its log lines and to the jobs it enqueues. Jobs are traced as
+// children of the span of the event.
+func (wh *WebhookHandler) handleEvent(event webhookEvent) {
+	_, span := tracing.Start(tracing.Continue(event.Trace), "handle "+event.Type, trace.WithAttributes(
+		attribute.String("delivery.id", event.DeliveryId),
+	))
+	defer span.End()
+
+	delivery := *wh
+	delivery.Logger = wh.logger().With("delivery_id", event.DeliveryId, "event", event.Type)
+	delivery.Deployments = deliveryQueue{wh.Deployments, event.DeliveryId, span.SpanContext()}
+
+	switch e := event.Event.(type) {
+	case *github.PushEvent:
+		delivery.handlePushEvent(e)
+	case *github.CreateEvent:
+		delivery.handleCreateEvent(e)
+	case *github.DeleteEvent:
+		delivery.handleBranchDeleteEvent(e)
+	case *github.PullRequestEvent:
+		delivery.handlePullRequestEvent(e, isDraft(event.Payload))
+	case *github.IssueCommentEvent:
+		delivery.handleIssueCommentEvent(e)
+	default:
+		delivery.Logger.Info("No handler for event")
 	}
+}
 
-	branch := strings.Split(e.GetRef(), "/")[2]
-	server := staging.NewServer(branch, func(message string, progress int) {
-		fmt.Printf("%s %d\n", message, progress)
-		wh.Messenger.Send(fmt.Sprintf("%s %d\n", message, progress))
-	})
+type deliveryQueue struct {
+	queue      deployment.Queue
+	deliveryId string
+	trace      trace.SpanContext
+}
+
+func (q deliveryQueue) Enqueue(job deployment.Job) {
+	job.DeliveryId = q.deliveryId
+	job.Trace = q.trace
+	q.queue.Enqueue(job)
+}
 
-	if server.Exists() && server.CanBeFastForwarded() {
-		server.Update()
-		fmt.Printf("Staging server updated at https://" + server.ServerName())
-		wh.Messenger.Send(fmt.Sprintf("%s: Staging server updated at https://%s", branch, server.ServerName()))
+func (wh *WebhookHandler) logger() *slog.Logger {
+	if wh.Logger == nil {
+		return slog.Default()
 	}
+	return wh.Logger
 }
 
-func (wh *WebhookHandler) handleBranchDeleteEvent(event interface{}) {
-	e, ok := event.(*github.DeleteEvent)
-	if !ok {
-		fmt.Println("Not a delete event")
+func (wh *WebhookHandler) handlePushEvent(e *github.PushEvent) {
+	if !strings.HasPrefix(e.GetRef(), "refs/heads/") || e.GetDeleted() {
 		return
 	}
 
-	if *e.RefType != "branch" {
-		fmt.Print("Not a branch")
+	wh.Deployments.Enqueue(deployment.Job{
+		Action:  deployment.Update,
+		Branch:  strings.TrimPrefix(e.GetRef(), "refs/heads/"),
+		Sha:     e.GetAfter(),
+		Trigger: deployment.TriggerPush,
+	})
+}
+
+func (wh *WebhookHandler) handleCreateEvent(e *github.CreateEvent) {
+	repo, _ := wh.repo(e.GetRepo().GetFullName())
+	if e.GetRefType() != "branch" || !repo.autoDeploys(e.GetRef()) {
 		return
 	}
 
-	branch := *e.Ref
-
-	server := staging.NewServer(branch, func(message string, progress int) {
-		fmt.Printf("%s %d\n", message, progress)
-		wh.Messenger.Send(fmt.Sprintf("%s: %s %d", branch, message, progress))
+	wh.Deployments.Enqueue(deployment.Job{
+		Action:  deployment.Deploy,
+		Branch:  e.GetRef(),
+		Trigger: deployment.TriggerCreate,
 	})
+}
 
-	if server.Exists() {
-		err := server.Remove()
-		if err != nil {
-			fmt.Println("Could not remove branch")
-			wh.Messenger.Send(fmt.Sprintf("%s: Could not remove branch", branch))
-		} else {
-			wh.Messenger.Send(fmt.Sprintf("%s: Staging server deleted", branch))
-		}
+func (wh *WebhookHandler) handleBranchDeleteEvent(e *github.DeleteEvent) {
+	if e.GetRefType() != "branch" {
+		return
 	}
+
+	wh.Deployments.Enqueue(deployment.Job{
+		Action:  deployment.Remove,
+		Branch:  e.GetRef(),
+		Trigger: deployment.TriggerDelete,
+	})
 }
 
-func (wh WebhookHandler) handlePullRequestEvent(event interface{}) {
-	e, ok := event.(*github.PullRequestEvent)
-	if !ok {
-		fmt.Println("Not a pull request event")
+func (wh *WebhookHandler) handlePullRequestEvent(e *github.PullRequestEvent, draft bool) {
+	repo, _ := wh.repo(e.GetRepo().GetFullName())
+	pr := e.GetPullRequest()
+	job := pullRequestJob(pr, deployment.TriggerPullRequest)
+
+	if isFork(pr) {
+		wh.handleForkPullRequestEvent(e, repo, job)
 		return
 	}
 
-	if !(*e.Action == "opened" || *e.Action == "synchronize" || *e.Action == "reopened") {
+	switch e.GetAction() {
+	case "opened", "synchronize", "reopened", "ready_for_review":
+	case "labeled":
+		if repo.RequireLabel == "" || e.GetLabel().GetName() != repo.RequireLabel {
+			return
+		}
+	case "unlabeled":
+		if repo.RequireLabel != "" && e.GetLabel().GetName() == repo.RequireLabel {
+			job.Action = deployment.Remove
+			wh.Deployments.Enqueue(job)
+		}
+		return
+	default:
 		return
 	}
 
-	commenter := messenger.GithubCommenter{
-		PrNumber: *e.PullRequest.Number,
+	if draft && !repo.DeployDrafts {
+		wh.logger().Info("Not deploying draft pull request", "branch", job.Branch, "pull_request", job.PrNumber)
+		return
 	}
-	branch := *e.PullRequest.Head.Ref
-	server := staging.NewServer(branch, func(message string, progress int) {
-		fmt.Printf("%s %d\n", message, progress)
-		commenter.UpdateProgress(message, progress)
-		wh.Messenger.Send(fmt.Sprintf("%s: %s %d %%", branch, message, progress))
-	})
+	if repo.RequireLabel != "" && !hasLabel(pr, repo.RequireLabel) {
+		wh.logger().Info("Not deploying pull request without label", "branch", job.Branch, "pull_request", job.PrNumber, "label", repo.RequireLabel)
+		return
+	}
+	wh.Deployments.Enqueue(job)
+}
 
-	if server.Exists() {
-		if server.CanBeFastForwarded() {
-			server.Update()
-			fmt.Println("Staging server updated at https://" + server.ServerName())
-			wh.Messenger.Send(fmt.Sprintf("%s: Staging server updated at https://%s", branch, server.ServerName()))
+// handleForkPullRequestEvent only deploys pull requests from forks when a
+// maintainer adds the approval label or runs /staging deploy, since they run
+// code from outside the organization. New commits need a new approval.
+func (wh *WebhookHandler) handleForkPullRequestEvent(e *github.PullRequestEvent, repo Repo, job deployment.Job) {
+	switch e.GetAction() {
+	case "labeled":
+		if e.GetLabel().GetName() == repo.approvalLabel() {
+			wh.Deployments.Enqueue(job)
 		}
-	} else {
-		commenter.StartingDeploy()
-		err := server.Deploy()
-		if err != nil {
-			fmt.Printf("Could not create staging server: %s\n", err)
-			wh.Messenger.Send(fmt.Sprintf("%s: Could not create staging server: %s", branch, err))
-			commenter.Delete()
-			server.Remove()
-		} else {
-			commenter.EditComment(commenter.ProgressCommentId, "Staging server deployed at https://"+server.ServerName())
-			wh.Messenger.Send(fmt.Sprintf("%s: Staging server deployed at https://%s", branch, server.ServerName()))
+	case "closed":
+		job.Action = deployment.Remove
+		wh.Deployments.Enqueue(job)
+	case "opened", "reopened", "synchronize":
+		wh.logger().Info("Pull request is from a fork and needs approval", "branch", job.Branch, "pull_request", job.PrNumber)
+		if wh.Github == nil {
+			return
+		}
+		message := fmt.Sprintf("This pull request is from a fork. A maintainer can deploy it to a staging server "+
+			"by adding the `%s` label or commenting `/staging deploy`.", repo.approvalLabel())
+		if _, err := messenger.NewGithubCommenter(wh.Github, job.PrNumber).Comment(message); err != nil {
+			wh.logger().Warn("Could not comment on pull request", "pull_request", job.PrNumber, "error", err)
+		}
+	}
+}
+
+// pullRequestJob deploys pull requests from forks from refs/pull/<n>/head as
+// pr-<n>, so they can not collide with branches in the repository.
+func pullRequestJob(pr *github.PullRequest, trigger string) deployment.Job {
+	job := deployment.Job{
+		Action:   deployment.Deploy,
+		Branch:   pr.GetHead().GetRef(),
+		Sha:      pr.GetHead().GetSHA(),
+		Trigger:  trigger,
+		PrNumber: pr.GetNumber(),
+	}
+	if isFork(pr) {
+		job.Branch = fmt.Sprintf("pr-%d", pr.GetNumber())
+		job.Ref = fmt.Sprintf("refs/pull/%d/head", pr.GetNumber())
+	}
+	return job
+}
+
+func isFork(pr *github.PullRequest) bool {
+	return !strings.EqualFold(pr.GetHead().GetRepo().GetFullName(), pr.GetBase().GetRepo().GetFullName())
+}
+
+func hasLabel(pr *github.PullRequest, name string) bool {
+	for _, label := range pr.Labels {
+		if label.GetName() == name {
+			return true
 		}
 	}
-}
\ No newline at end of file
+	return false
+}
+
+// isDraft reads the draft flag of a pull request payload, which go-github
+// does not support yet.
+func isDraft(payload []byte) bool {
+	var e struct {
+		PullRequest struct {
+			Draft bool `json:"draft"`
+		} `json:"pull_request"`
+	}
+	json.Unmarshal(payload, &e)
+	return e.PullRequest.Draft
+}
@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+	"github.com/gorilla/mux"
+	"github.com/vektorprogrammet/build-system/deployment"
+	"github.com/vektorprogrammet/build-system/tracing/tracingtest"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+const testWebhookSecret = "webhook-secret"
+
+func newTestWebhookHandler() *WebhookHandler {
+	wh := &WebhookHandler{
+		Secret: []byte(testWebhookSecret),
+		Router: mux.NewRouter().PathPrefix("/webhooks/").Subrouter(),
+		Repos:  []Repo{{Name: "vektorprogrammet/vektorprogrammet"}},
+	}
+	wh.Router.HandleFunc("/github", wh.handleWebhook).Methods("POST")
+	eventChan = make(chan webhookEvent, 1)
+	return wh
+}
+
+func signedWebhookRequest(t *testing.T, event, fixture, secret string) *http.Request {
+	payload, err := ioutil.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write(payload)
+
+	r := httptest.NewRequest("POST", "/webhooks/github", bytes.NewReader(payload))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-GitHub-Event", event)
+	r.Header.Set("X-GitHub-Delivery", "72d3162e-cc78-11e3-81ab-4c9367dc0958")
+	r.Header.Set("X-Hub-Signature", "sha1="+hex.EncodeToString(mac.Sum(nil)))
+	return r
+}
+
+func TestWebhook_Responses(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		request  func() *http.Request
+		expected int
+	}{
+		{"push", func() *http.Request { return signedWebhookRequest(t, "push", "push.json", testWebhookSecret) }, http.StatusAccepted},
+		{"pull request", func() *http.Request {
+			return signedWebhookRequest(t, "pull_request", "pull_request.json", testWebhookSecret)
+		}, http.StatusAccepted},
+		{"delete", func() *http.Request { return signedWebhookRequest(t, "delete", "delete.json", testWebhookSecret) }, http.StatusAccepted},
+		{"wrong secret", func() *http.Request { return signedWebhookRequest(t, "push", "push.json", "wrong-secret") }, http.StatusUnauthorized},
+		{"unsigned", func() *http.Request {
+			r := signedWebhookRequest(t, "push", "push.json", testWebhookSecret)
+			r.Header.Del("X-Hub-Signature")
+			return r
+		}, http.StatusUnauthorized},
+		{"unsupported event", func() *http.Request { return signedWebhookRequest(t, "gollum", "push.json", testWebhookSecret) }, http.StatusBadRequest},
+		{"other repository", func() *http.Request {
+			return signedWebhookRequest(t, "push", "push_other_repo.json", testWebhookSecret)
+		}, http.StatusBadRequest},
+		{"invalid payload", func() *http.Request {
+			r := signedWebhookRequest(t, "push", "push.json", testWebhookSecret)
+			body := []byte("{not json")
+			mac := hmac.New(sha1.New, []byte(testWebhookSecret))
+			mac.Write(body)
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			r.Header.Set("X-Hub-Signature", "sha1="+hex.EncodeToString(mac.Sum(nil)))
+			return r
+		}, http.StatusBadRequest},
+		{"too large", func() *http.Request {
+			r := signedWebhookRequest(t, "push", "push.json", testWebhookSecret)
+			r.Body = ioutil.NopCloser(strings.NewReader(strings.Repeat(" ", maxWebhookSize+1)))
+			return r
+		}, http.StatusRequestEntityTooLarge},
+	} {
+		wh := newTestWebhookHandler()
+		w := httptest.NewRecorder()
+		wh.Router.ServeHTTP(w, test.request())
+
+		if w.Code != test.expected {
+			t.Errorf("%s: Expected status %d, got %d: %s", test.name, test.expected, w.Code, w.Body.String())
+		}
+		if w.Code == http.StatusAccepted {
+			<-eventChan
+		}
+	}
+}
+
+func TestWebhook_IgnoresOtherRepositoriesEvenIfConfigured(t *testing.T) {
+	wh := newTestWebhookHandler()
+	wh.Repos = append(wh.Repos, Repo{Name: "octocat/Hello-World"})
+
+	w := httptest.NewRecorder()
+	wh.Router.ServeHTTP(w, signedWebhookRequest(t, "push", "push_other_repo.json", testWebhookSecret))
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	if len(eventChan) != 0 {
+		t.Errorf("Expected the push to another repository to be ignored")
+	}
+	if err := CheckRepos(wh.Repos); err == nil {
+		t.Errorf("Expected other repositories to be refused at startup")
+	}
+}
+
+func TestWebhook_RefusesEmptySecret(t *testing.T) {
+	wh := newTestWebhookHandler()
+	wh.Secret = nil
+	w := httptest.NewRecorder()
+	wh.Router.ServeHTTP(w, signedWebhookRequest(t, "push", "push.json", ""))
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestWebhook_IgnoresDuplicateDeliveries(t *testing.T) {
+	wh := newTestWebhookHandler()
+	wh.Deliveries = newTestDeliveryStore(t)
+	defer os.RemoveAll(wh.Deliveries.Folder)
+
+	for i, expected := range []int{http.StatusAccepted, http.StatusOK} {
+		w := httptest.NewRecorder()
+		wh.Router.ServeHTTP(w, signedWebhookRequest(t, "push", "push.json", testWebhookSecret))
+		if w.Code != expected {
+			t.Errorf("Expected delivery %d to get status %d, got %d", i+1, expected, w.Code)
+		}
+	}
+
+	event := <-eventChan
+	if e, ok := event.Event.(*github.PushEvent); !ok || e.GetAfter() != "6dcb09b5b57875f334f61aebed695e2e4193db5e" {
+		t.Errorf("Expected push event to be dispatched, got %#v", event)
+	}
+	select {
+	case event := <-eventChan:
+		t.Errorf("Expected duplicate delivery to be ignored, got %#v", event)
+	default:
+	}
+}
+
+func TestWebhook_Ping(t *testing.T) {
+	wh := newTestWebhookHandler()
+	w := httptest.NewRecorder()
+	wh.Router.ServeHTTP(w, signedWebhookRequest(t, "ping", "ping.json", testWebhookSecret))
+
+	if w.Code != http.StatusOK || w.Body.String() != "pong" {
+		t.Errorf("Expected pong, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func fixtureEvent(t *testing.T, eventType, fixture string) webhookEvent {
+	payload, err := ioutil.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := github.ParseWebHook(eventType, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return webhookEvent{Type: eventType, Event: event, Payload: payload}
+}
+
+func TestWebhook_DispatchRules(t *testing.T) {
+	labelRules := Repo{Name: "vektorprogrammet/vektorprogrammet", RequireLabel: "staging"}
+
+	for _, test := range []struct {
+		name     string
+		repo     Repo
+		event    string
+		fixture  string
+		expected []deployment.Job
+	}{
+		{"push", Repo{}, "push", "push.json", []deployment.Job{
+			{Action: deployment.Update, Branch: "feature", Sha: "6dcb09b5b57875f334f61aebed695e2e4193db5e", Trigger: deployment.TriggerPush},
+		}},
+		{"pull request", Repo{}, "pull_request", "pull_request.json", []deployment.Job{
+			{Action: deployment.Deploy, Branch: "feature", Sha: "6dcb09b5b57875f334f61aebed695e2e4193db5e", Trigger: deployment.TriggerPullRequest, PrNumber: 42},
+		}},
+		{"draft", Repo{}, "pull_request", "pull_request_draft.json", nil},
+		{"draft allowed", Repo{DeployDrafts: true}, "pull_request", "pull_request_draft.json", []deployment.Job{
+			{Action: deployment.Deploy, Branch: "feature", Sha: "6dcb09b5b57875f334f61aebed695e2e4193db5e", Trigger: deployment.TriggerPullRequest, PrNumber: 42},
+		}},
+		{"missing label", labelRules, "pull_request", "pull_request.json", nil},
+		{"labeled", labelRules, "pull_request", "pull_request_labeled.json", []deployment.Job{
+			{Action: deployment.Deploy, Branch: "feature", Sha: "6dcb09b5b57875f334f61aebed695e2e4193db5e", Trigger: deployment.TriggerPullRequest, PrNumber: 42},
+		}},
+		{"unlabeled", labelRules, "pull_request", "pull_request_unlabeled.json", []deployment.Job{
+			{Action: deployment.Remove, Branch: "feature", Sha: "6dcb09b5b57875f334f61aebed695e2e4193db5e", Trigger: deployment.TriggerPullRequest, PrNumber: 42},
+		}},
+		{"labeled without rules", Repo{}, "pull_request", "pull_request_labeled.json", nil},
+		{"fork", Repo{}, "pull_request", "pull_request_fork.json", nil},
+		{"fork approved", Repo{}, "pull_request", "pull_request_fork_approved.json", []deployment.Job{
+			{Action: deployment.Deploy, Branch: "pr-42", Sha: "6dcb09b5b57875f334f61aebed695e2e4193db5e", Ref: "refs/pull/42/head", Trigger: deployment.TriggerPullRequest, PrNumber: 42},
+		}},
+		{"fork closed", Repo{}, "pull_request", "pull_request_fork_closed.json", []deployment.Job{
+			{Action: deployment.Remove, Branch: "pr-42", Sha: "6dcb09b5b57875f334f61aebed695e2e4193db5e", Ref: "refs/pull/42/head", Trigger: deployment.TriggerPullRequest, PrNumber: 42},
+		}},
+		{"create", Repo{}, "create", "create.json", nil},
+		{"create auto deploy", Repo{AutoDeployBranches: []string{"release/*"}}, "create", "create.json", []deployment.Job{
+			{Action: deployment.Deploy, Branch: "release/2018-autumn", Trigger: deployment.TriggerCreate},
+		}},
+		{"delete", Repo{}, "delete", "delete.json", []deployment.Job{
+			{Action: deployment.Remove, Branch: "feature", Trigger: deployment.TriggerDelete},
+		}},
+	} {
+		queue := &testQueue{}
+		test.repo.Name = "vektorprogrammet/vektorprogrammet"
+		wh := &WebhookHandler{Repos: []Repo{test.repo}, Deployments: queue}
+		wh.handleEvent(fixtureEvent(t, test.event, test.fixture))
+
+		if !reflect.DeepEqual(queue.jobs, test.expected) {
+			t.Errorf("%s: Expected jobs %+v, got %+v", test.name, test.expected, queue.jobs)
+		}
+	}
+}
+
+func TestWebhook_TracesDeliveryToJobs(t *testing.T) {
+	exporter, stop := tracingtest.Record()
+	defer stop()
+
+	queue := &testQueue{}
+	wh := newTestWebhookHandler()
+	wh.Deployments = queue
+	w := httptest.NewRecorder()
+	wh.Router.ServeHTTP(w, signedWebhookRequest(t, "push", "push.json", testWebhookSecret))
+	wh.handleEvent(<-eventChan)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range exporter.GetSpans().Snapshots() {
+		spans[span.Name()] = span
+	}
+	webhook, validation, handle := spans["github webhook"], spans["validate signature"], spans["handle push"]
+	if webhook == nil || validation == nil || handle == nil {
+		t.Fatalf("Expected webhook, signature and handler spans, got %v", spans)
+	}
+	if validation.Parent().SpanID() != webhook.SpanContext().SpanID() || handle.Parent().SpanID() != webhook.SpanContext().SpanID() {
+		t.Errorf("Expected the signature and handler spans to be children of the webhook span")
+	}
+	if len(queue.jobs) != 1 || queue.jobs[0].Trace.SpanID() != handle.SpanContext().SpanID() {
+		t.Errorf("Expected the job to be traced as a child of the handler span, got %+v", queue.jobs)
+	}
+}
@@ -0,0 +1,240 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Check verifies that a staging server responds after it has been deployed.
+type Check struct {
+	// Paths are requested relative to the URL of the server.
+	Paths []string `json:"paths"`
+	// Status is the expected status code of every path.
+	Status int `json:"status"`
+	// Body must be contained in the responses when it is set.
+	Body            string `json:"body"`
+	Retries         int    `json:"retries"`
+	IntervalSeconds int    `json:"interval_seconds"`
+	TimeoutSeconds  int    `json:"timeout_seconds"`
+	// MaxSeconds bounds the whole check, since deploys wait for it. Paths
+	// are not retried once it has passed.
+	MaxSeconds int          `json:"max_seconds"`
+	Client     *http.Client `json:"-"`
+}
+
+func DefaultCheck() Check {
+	return Check{
+		Paths:           []string{"/"},
+		Status:          http.StatusOK,
+		Retries:         5,
+		IntervalSeconds: 5,
+		TimeoutSeconds:  10,
+		MaxSeconds:      60,
+	}
+}
+
+// LoadCheck reads a check from a JSON file. Settings that are missing from
+// the file, or a missing file, use the defaults.
+func LoadCheck(path string) (Check, error) {
+	check := DefaultCheck()
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return check, nil
+	}
+	if err != nil {
+		return check, err
+	}
+
+	err = json.Unmarshal(data, &check)
+	return check, err
+}
+
+type Result struct {
+	Path      string `json:"path"`
+	Status    int    `json:"status"`
+	LatencyMs int64  `json:"latency_ms"`
+	Attempts  int    `json:"attempts"`
+	Error     string `json:"error,omitempty"`
+}
+
+type Report struct {
+	Healthy bool      `json:"healthy"`
+	Time    time.Time `json:"time"`
+	Results []Result  `json:"results"`
+}
+
+func (r Report) String() string {
+	var lines []string
+	for _, result := range r.Results {
+		if result.Error != "" {
+			lines = append(lines, fmt.Sprintf("GET %s failed after %d attempts: %s", result.Path, result.Attempts, result.Error))
+		} else {
+			lines = append(lines, fmt.Sprintf("GET %s %d in %dms", result.Path, result.Status, result.LatencyMs))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Run requests every path on baseUrl, retrying each path until it responds
+// as expected, runs out of retries or the check runs out of time.
+func (c Check) Run(baseUrl string) Report {
+	paths := c.Paths
+	if len(paths) == 0 {
+		paths = []string{"/"}
+	}
+
+	report := Report{Healthy: true, Time: time.Now()}
+	var deadline time.Time
+	if c.MaxSeconds > 0 {
+		deadline = report.Time.Add(time.Duration(c.MaxSeconds) * time.Second)
+	}
+	interval := time.Duration(c.IntervalSeconds) * time.Second
+	for _, path := range paths {
+		result := Result{Path: path, Error: "health check ran out of time"}
+		for attempt := 1; attempt <= c.Retries+1; attempt++ {
+			if attempt > 1 {
+				if !deadline.IsZero() && time.Now().Add(interval).After(deadline) {
+					break
+				}
+				time.Sleep(interval)
+			} else if !deadline.IsZero() && time.Now().After(deadline) {
+				break
+			}
+			result = c.probe(baseUrl, path)
+			result.Attempts = attempt
+			if result.Error == "" {
+				break
+			}
+		}
+		if result.Error != "" {
+			report.Healthy = false
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report
+}
+
+func (c Check) probe(baseUrl, path string) Result {
+	result := Result{Path: path}
+	client := c.Client
+	if client == nil {
+		timeout := c.TimeoutSeconds
+		if timeout == 0 {
+			timeout = 10
+		}
+		client = &http.Client{Timeout: time.Duration(timeout) * time.Second}
+	}
+
+	start := time.Now()
+	response, err := client.Get(strings.TrimRight(baseUrl, "/") + path)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	result.LatencyMs = int64(time.Since(start) / time.Millisecond)
+	result.Status = response.StatusCode
+
+	expected := c.Status
+	if expected == 0 {
+		expected = http.StatusOK
+	}
+	switch {
+	case err != nil:
+		result.Error = err.Error()
+	case response.StatusCode != expected:
+		result.Error = fmt.Sprintf("expected status %d, got %d", expected, response.StatusCode)
+	case c.Body != "" && !strings.Contains(string(body), c.Body):
+		result.Error = fmt.Sprintf("response does not contain %q", c.Body)
+	}
+	return result
+}
+
+// LoadReport reads a report saved with SaveReport. It returns nil if there
+// is no report.
+func LoadReport(path string) (*Report, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var report Report
+	err = json.Unmarshal(data, &report)
+	return &report, err
+}
+
+func SaveReport(path string, report Report) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// AppendHistory adds a report to the history in path and drops the oldest
+// reports when there are more than max.
+func AppendHistory(path string, report Report, max int) error {
+	history, err := LoadHistory(path)
+	if err != nil {
+		return err
+	}
+
+	history = append(history, report)
+	if len(history) > max {
+		history = history[len(history)-max:]
+	}
+	data, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// LoadHistory reads the reports saved with AppendHistory, oldest first.
+func LoadHistory(path string) ([]Report, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var history []Report
+	err = json.Unmarshal(data, &history)
+	return history, err
+}
+
+// Uptime is the percentage of healthy reports in a history.
+func Uptime(history []Report) float64 {
+	if len(history) == 0 {
+		return 0
+	}
+	healthy := 0
+	for _, report := range history {
+		if report.Healthy {
+			healthy++
+		}
+	}
+	return 100 * float64(healthy) / float64(len(history))
+}
+
+// LatencyMs is the slowest response of the report.
+func (r Report) LatencyMs() int64 {
+	var latency int64
+	for _, result := range r.Results {
+		if result.LatencyMs > latency {
+			latency = result.LatencyMs
+		}
+	}
+	return latency
+}
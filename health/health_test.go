@@ -0,0 +1,86 @@
+package health
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckRetriesUntilHealthy(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("<title>Vektorprogrammet</title>"))
+	}))
+	defer server.Close()
+
+	report := Check{Paths: []string{"/"}, Body: "Vektorprogrammet", Retries: 3}.Run(server.URL)
+	if !report.Healthy {
+		t.Fatalf("Expected healthy report, got %s", report)
+	}
+	if result := report.Results[0]; result.Attempts != 3 || result.Status != http.StatusOK {
+		t.Errorf("Expected 200 after 3 attempts, got %+v", result)
+	}
+}
+
+func TestCheckFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("Whoops, looks like something went wrong."))
+	}))
+	defer server.Close()
+
+	for _, check := range []Check{
+		{Paths: []string{"/", "/missing"}, Retries: 1},
+		{Paths: []string{"/"}, Body: "Vektorprogrammet"},
+		{Paths: []string{"/"}, Status: http.StatusNoContent},
+	} {
+		report := check.Run(server.URL)
+		if report.Healthy {
+			t.Errorf("Expected %+v to fail, got %s", check, report)
+		}
+	}
+}
+
+func TestCheckStopsRetryingAtMaxSeconds(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	start := time.Now()
+	report := Check{Paths: []string{"/", "/login"}, Retries: 10, IntervalSeconds: 1, MaxSeconds: 1}.Run(server.URL)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the check to stop after about a second, took %s", elapsed)
+	}
+	if report.Healthy || len(report.Results) != 2 || report.Results[0].Attempts != 1 {
+		t.Errorf("Expected a failed check without retries, got %+v", report)
+	}
+}
+
+func TestLoadCheckDefaults(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "health")
+	defer os.RemoveAll(dir)
+
+	check, err := LoadCheck(filepath.Join(dir, "missing.json"))
+	if err != nil || check.Status != http.StatusOK || len(check.Paths) != 1 {
+		t.Errorf("Expected the default check, got %+v, %v", check, err)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "health.json"), []byte(`{"paths": ["/", "/login"], "body": "Vektor"}`), 0644)
+	check, err = LoadCheck(filepath.Join(dir, "health.json"))
+	if err != nil || len(check.Paths) != 2 || check.Body != "Vektor" || check.Retries != 5 {
+		t.Errorf("Expected configured paths and default retries, got %+v, %v", check, err)
+	}
+}
@@ -0,0 +1,103 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// New creates a logger that writes JSON, or text if format is "text", at
+// level or above.
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	var l slog.Level
+	if level != "" {
+		if err := l.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("unknown log level %q", level)
+		}
+	}
+
+	options := &slog.HandlerOptions{Level: l}
+	switch strings.ToLower(format) {
+	case "", "json":
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q", format)
+}
+
+// FromEnv creates a logger from LOG_LEVEL and LOG_FORMAT that writes to
+// stdout.
+func FromEnv() (*slog.Logger, error) {
+	return New(os.Stdout, os.Getenv("LOG_LEVEL"), os.Getenv("LOG_FORMAT"))
+}
+
+// NewId returns a random id for correlating log lines.
+func NewId() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+type contextKey struct{}
+
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger of a request, or fallback outside of
+// requests.
+func FromContext(ctx context.Context, fallback *slog.Logger) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	if fallback == nil {
+		return slog.Default()
+	}
+	return fallback
+}
+
+// Middleware gives every request an id, which is returned in X-Request-Id,
+// puts a logger with the id in the request context and logs the request when
+// it is done. GitHub webhook requests are logged with their delivery id.
+func Middleware(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-Id")
+		if id == "" {
+			id = NewId()
+		}
+		w.Header().Set("X-Request-Id", id)
+
+		requestLogger := logger.With("request_id", id)
+		if delivery := r.Header.Get("X-GitHub-Delivery"); delivery != "" {
+			requestLogger = requestLogger.With("delivery_id", delivery)
+		}
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(WithLogger(r.Context(), requestLogger)))
+		requestLogger.Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
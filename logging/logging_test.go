@@ -0,0 +1,67 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := New(&out, "warn", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger.Info("hidden")
+	logger.Warn("shown", "branch", "feature")
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("Expected a single JSON line, got %q: %s", out.String(), err)
+	}
+	if line["msg"] != "shown" || line["branch"] != "feature" {
+		t.Errorf("Expected the warning with its branch, got %v", line)
+	}
+
+	if _, err := New(&out, "loud", "json"); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+	if _, err := New(&out, "info", "xml"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var out bytes.Buffer
+	logger, _ := New(&out, "info", "json")
+
+	handler := Middleware(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		FromContext(r.Context(), nil).Info("handling")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	r := httptest.NewRequest("POST", "/webhooks/github", nil)
+	r.Header.Set("X-GitHub-Delivery", "72d3162e")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	id := w.Header().Get("X-Request-Id")
+	if id == "" {
+		t.Fatal("Expected a request id")
+	}
+	decoder := json.NewDecoder(&out)
+	for _, msg := range []string{"handling", "request"} {
+		var line map[string]interface{}
+		if err := decoder.Decode(&line); err != nil {
+			t.Fatal(err)
+		}
+		if line["msg"] != msg || line["request_id"] != id || line["delivery_id"] != "72d3162e" {
+			t.Errorf("Expected %s with request and delivery id, got %v", msg, line)
+		}
+		if msg == "request" && line["status"] != float64(http.StatusAccepted) {
+			t.Errorf("Expected status 202, got %v", line["status"])
+		}
+	}
+}
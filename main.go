@@ -1,45 +1,261 @@
 package main
 
 import (
-	"fmt"
-	"log"
+	"context"
+	"log/slog"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/cors"
+	"github.com/vektorprogrammet/build-system/auth"
 	"github.com/vektorprogrammet/build-system/cli"
+	"github.com/vektorprogrammet/build-system/deployment"
+	"github.com/vektorprogrammet/build-system/githubclient"
 	"github.com/vektorprogrammet/build-system/handlers"
-
-	"os"
+	"github.com/vektorprogrammet/build-system/health"
+	"github.com/vektorprogrammet/build-system/logging"
 	"github.com/vektorprogrammet/build-system/messenger"
+	"github.com/vektorprogrammet/build-system/metrics"
+	"github.com/vektorprogrammet/build-system/monitor"
+	"github.com/vektorprogrammet/build-system/staging"
+	"github.com/vektorprogrammet/build-system/tracing"
 )
 
 func main() {
+	flushTraces, err := tracing.FromEnv(context.Background())
+	if err != nil {
+		fatal("Could not configure tracing", err)
+	}
 	keepRunning := cli.HandleArguments()
 	if !keepRunning {
+		flushTraces(context.Background())
 		return
 	}
 
+	logger, err := logging.FromEnv()
+	if err != nil {
+		fatal("Could not configure logging", err)
+	}
+	slog.SetDefault(logger)
+
 	secret := os.Getenv("GITHUB_WEBHOOKS_SECRET")
-	slack := messenger.NewSlack(os.Getenv("SLACK_ENDPOINT"), "#staging_log", "vektorbot", ":robot_face:")
+	if secret == "" {
+		fatal("GITHUB_WEBHOOKS_SECRET must be set", nil)
+	}
+	notifications := messenger.MultiMessenger{}
+	if token := os.Getenv("SLACK_BOT_TOKEN"); token != "" {
+		slackLogger := logger.With("messenger", "slack")
+		slackMessenger := messenger.NewSlack(token, "#staging_log", "vektorbot", ":robot_face:")
+		slackMessenger.Logger = slackLogger
+		slack := messenger.NewOutbox(slackMessenger)
+		slack.Logger = slackLogger
+		slack.Start()
+		notifications = append(notifications, slack)
+	} else if os.Getenv("SLACK_ENDPOINT") != "" {
+		logger.Warn("SLACK_ENDPOINT is no longer used, set SLACK_BOT_TOKEN to post notifications to Slack")
+	}
+
+	templates, err := messenger.LoadTemplates(staging.DefaultInstallationFolder + "/templates")
+	if err != nil {
+		fatal("Could not load notification templates", err)
+	}
+	messenger.UseTemplates(templates)
+
+	backends, err := messenger.LoadNotifications(staging.DefaultInstallationFolder+"/notifications.json", logger)
+	if err != nil {
+		fatal("Could not load notification backends", err)
+	}
+	for _, backend := range backends {
+		outbox := messenger.NewOutbox(backend)
+		outbox.Start()
+		notifications = append(notifications, outbox)
+	}
+
+	githubClients, err := githubclient.FromEnv()
+	if err != nil {
+		fatal("Could not configure GitHub client", err)
+	}
+
+	engine := deployment.NewEngine(notifications, githubClients)
+	engine.PublicUrl = os.Getenv("PUBLIC_URL")
+	engine.Logger = logger
+	healthCheck, err := health.LoadCheck(staging.DefaultInstallationFolder + "/health.json")
+	if err != nil {
+		fatal("Could not load health check", err)
+	}
+	engine.HealthCheck = &healthCheck
+	engine.Start()
+
+	listServers := func() []staging.Server { return cli.ListServers(logger) }
+	uptime := monitor.NewMonitor(healthCheck, listServers, notifications)
+	uptime.Logger = logger
+	uptime.Busy = engine.Busy
+	if interval := os.Getenv("MONITOR_INTERVAL"); interval != "" {
+		if uptime.Interval, err = time.ParseDuration(interval); err != nil {
+			fatal("Could not parse MONITOR_INTERVAL", err)
+		}
+	}
+	uptime.Start()
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "staging_queue_depth",
+		Help: "Jobs waiting for the deployment engine.",
+	}, func() float64 {
+		return float64(engine.QueueDepth())
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "staging_active_servers",
+		Help: "Deployed staging servers.",
+	}, func() float64 {
+		return float64(len(listServers()))
+	})
+	prometheus.MustRegister(&metrics.DiskCollector{
+		Space: func() (int, int, error) {
+			return handlers.DiskSpace(staging.DefaultRootFolder)
+		},
+		Logger: logger,
+	})
+
+	deliveries, err := handlers.NewDeliveryStore(staging.DefaultInstallationFolder + "/deliveries")
+	if err != nil {
+		fatal("Could not create delivery store", err)
+	}
+	go func() {
+		prune := time.NewTicker(time.Hour)
+		for {
+			if err := deliveries.Prune(30 * 24 * time.Hour); err != nil {
+				logger.Warn("Could not prune old deliveries", "error", err)
+			}
+			<-prune.C
+		}
+	}()
+
+	repos, err := handlers.LoadRepos(staging.DefaultInstallationFolder + "/repositories.json")
+	if err != nil {
+		fatal("Could not load repository rules", err)
+	}
+	if len(repos) == 0 {
+		for _, name := range envList("GITHUB_REPOSITORIES") {
+			repos = append(repos, handlers.Repo{Name: name})
+		}
+	}
+	if len(repos) == 0 {
+		repos = []handlers.Repo{{Name: githubclient.DefaultOwner + "/" + githubclient.DefaultRepo}}
+	}
+	if err := handlers.CheckRepos(repos); err != nil {
+		fatal("Invalid repository rules", err)
+	}
+
 	webhooks := handlers.WebhookHandler{
-		Secret: []byte(secret),
-		Router: mux.NewRouter().PathPrefix("/webhooks/").Subrouter(),
-		Messenger: slack,
+		Secret:      []byte(secret),
+		Router:      mux.NewRouter().PathPrefix("/webhooks/").Subrouter(),
+		Repos:       repos,
+		Deployments: engine,
+		Github:      githubClients,
+		Deliveries:  deliveries,
+		PublicUrl:   os.Getenv("PUBLIC_URL"),
+		Logger:      logger,
 	}
 	webhooks.InitRoutes()
 
+	slackCommands := handlers.SlackHandler{
+		SigningSecret: []byte(os.Getenv("SLACK_SIGNING_SECRET")),
+		Router:        webhooks.Router,
+		Deployments:   engine,
+		Github:        githubClients,
+		Roles:         map[string]auth.Role{},
+		Logger:        logger,
+	}
+	for _, id := range envList("SLACK_DEPLOYERS") {
+		slackCommands.Roles[id] = auth.Deployer
+	}
+	for _, id := range envList("SLACK_ADMINS") {
+		slackCommands.Roles[id] = auth.Admin
+	}
+	slackCommands.InitRoutes()
+
+	authenticators := auth.Any{auth.Tokens(envList("API_TOKENS"))}
+	var login *handlers.Login
+	if clientId := os.Getenv("GITHUB_OAUTH_CLIENT_ID"); clientId != "" {
+		sessionSecret := os.Getenv("SESSION_SECRET")
+		if sessionSecret == "" {
+			fatal("SESSION_SECRET must be set when GITHUB_OAUTH_CLIENT_ID is set", nil)
+		}
+		sessions := auth.NewSessions([]byte(sessionSecret))
+		github := auth.NewGitHub(
+			clientId,
+			os.Getenv("GITHUB_OAUTH_CLIENT_SECRET"),
+			os.Getenv("GITHUB_OAUTH_REDIRECT_URL"),
+			"vektorprogrammet",
+		)
+		github.ViewerTeams = envList("GITHUB_VIEWER_TEAMS")
+		github.DeployerTeams = envList("GITHUB_DEPLOYER_TEAMS")
+		github.AdminTeams = envList("GITHUB_ADMIN_TEAMS")
+
+		login = &handlers.Login{
+			Router:      mux.NewRouter().PathPrefix("/auth/").Subrouter(),
+			GitHub:      github,
+			Sessions:    sessions,
+			RedirectUrl: os.Getenv("DASHBOARD_URL"),
+		}
+		login.InitRoutes()
+		authenticators = append(authenticators, sessions)
+	}
+
 	api := handlers.Api{
-		Router: mux.NewRouter().PathPrefix("/api/").Subrouter(),
+		Router:      mux.NewRouter().PathPrefix("/api/").Subrouter(),
+		Auth:        authenticators,
+		Deployments: engine,
+		Webhooks:    &webhooks,
+		Github:      githubClients,
+		Logger:      logger,
 	}
 	api.InitRoutes()
 
 	serveMux := http.NewServeMux()
 	serveMux.Handle("/webhooks/", webhooks.Router)
 	serveMux.Handle("/api/", api.Router)
+	if login != nil {
+		serveMux.Handle("/auth/", login.Router)
+	}
+	serveMux.Handle("/metrics", promhttp.Handler())
+
+	var handler http.Handler = serveMux
+	if origins := envList("CORS_ALLOWED_ORIGINS"); len(origins) > 0 {
+		handler = cors.New(cors.Options{
+			AllowedOrigins:   origins,
+			AllowedMethods:   []string{"GET", "POST", "DELETE"},
+			AllowedHeaders:   []string{"Authorization", "Content-Type", auth.CSRFHeader},
+			AllowCredentials: true,
+		}).Handler(serveMux)
+	}
+	handler = logging.Middleware(logger, handler)
 
-	handler := cors.Default().Handler(serveMux)
+	logger.Info("Listening to webhooks", "port", 5555)
+	fatal("Server stopped", http.ListenAndServe(":5555", handler))
+}
 
-	fmt.Println("Listening to webhooks on port 5555")
-	log.Fatal(http.ListenAndServe(":5555", handler))
+func fatal(message string, err error) {
+	if err != nil {
+		slog.Error(message, "error", err)
+	} else {
+		slog.Error(message)
+	}
+	os.Exit(1)
+}
+
+func envList(name string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
 }
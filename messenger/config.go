@@ -0,0 +1,67 @@
+package messenger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+)
+
+type NotificationConfig struct {
+	Type        string   `json:"type"`
+	Filter      Filter   `json:"filter"`
+	Url         string   `json:"url"`
+	Secret      string   `json:"secret"`
+	Username    string   `json:"username"`
+	Password    string   `json:"password"`
+	SmtpAddress string   `json:"smtp_address"`
+	From        string   `json:"from"`
+	To          []string `json:"to"`
+}
+
+// LoadNotifications reads the notification backends from a JSON file. A
+// missing file means no extra backends. Backends that log use logger.
+func LoadNotifications(path string, logger *slog.Logger) ([]Messenger, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []NotificationConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, err
+	}
+
+	var messengers []Messenger
+	for _, config := range configs {
+		m, err := config.messenger(logger)
+		if err != nil {
+			return nil, err
+		}
+		messengers = append(messengers, &FilteredMessenger{Messenger: m, Filter: config.Filter})
+	}
+	return messengers, nil
+}
+
+func (c NotificationConfig) messenger(logger *slog.Logger) (Messenger, error) {
+	switch c.Type {
+	case "discord":
+		return NewDiscord(c.Url, c.Username), nil
+	case "teams":
+		return NewTeams(c.Url), nil
+	case "webhook":
+		return NewWebhook(c.Url, c.Secret), nil
+	case "email":
+		email := NewEmail(c.SmtpAddress, c.From, c.To)
+		email.Username = c.Username
+		email.Password = c.Password
+		email.Logger = logger
+		return email, nil
+	}
+
+	return nil, fmt.Errorf("unknown notification type %q", c.Type)
+}
@@ -0,0 +1,29 @@
+package messenger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Console prints notifications as plain text, for the CLI and the server log.
+type Console struct {
+	Out io.Writer
+}
+
+func NewConsole() Console {
+	return Console{Out: os.Stdout}
+}
+
+func (c Console) Send(ctx context.Context, message string) error {
+	_, err := fmt.Fprintln(c.Out, message)
+	return err
+}
+
+func (c Console) SendWithActions(ctx context.Context, message string, actions []Action) error {
+	if links := actionLinks(actions); links != "" {
+		message += "\n" + links
+	}
+	return c.Send(ctx, message)
+}
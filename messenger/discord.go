@@ -0,0 +1,46 @@
+package messenger
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const discordMessageLimit = 2000
+
+type Discord struct {
+	WebhookUrl string `json:"webhook_url"`
+	Username   string `json:"username"`
+}
+
+type discordMessage struct {
+	Content  string `json:"content"`
+	Username string `json:"username,omitempty"`
+}
+
+func NewDiscord(webhookUrl, username string) Discord {
+	return Discord{
+		WebhookUrl: webhookUrl,
+		Username:   username,
+	}
+}
+
+func (d Discord) Send(ctx context.Context, message string) error {
+	return postJson(ctx, d.WebhookUrl, discordMessage{
+		Content:  truncate(message, discordMessageLimit),
+		Username: d.Username,
+	}, nil)
+}
+
+func (d Discord) SendWithActions(ctx context.Context, message string, actions []Action) error {
+	var links []string
+	for _, action := range actions {
+		if action.Url != "" {
+			links = append(links, fmt.Sprintf("[%s](<%s>)", action.Text, action.Url))
+		}
+	}
+	if len(links) > 0 {
+		message += "\n" + strings.Join(links, " | ")
+	}
+	return d.Send(ctx, message)
+}
@@ -0,0 +1,157 @@
+package messenger
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// emailTimeout limits emails that are sent without a deadline, so a hung
+// SMTP server can not block the outbox.
+const emailTimeout = 30 * time.Second
+
+type Email struct {
+	SmtpAddress string   `json:"smtp_address"`
+	Username    string   `json:"username"`
+	Password    string   `json:"password"`
+	From        string   `json:"from"`
+	To          []string `json:"to"`
+	// Logger defaults to slog.Default.
+	Logger *slog.Logger `json:"-"`
+}
+
+func NewEmail(smtpAddress, from string, to []string) Email {
+	return Email{
+		SmtpAddress: smtpAddress,
+		From:        from,
+		To:          to,
+	}
+}
+
+func (e Email) Send(ctx context.Context, message string) error {
+	return e.mail(ctx, "Staging server notification", message)
+}
+
+func (e Email) SendWithActions(ctx context.Context, message string, actions []Action) error {
+	return e.mail(ctx, "Staging server notification", message+"\n\n"+actionLinks(actions))
+}
+
+func (e Email) TrackDeployment(d Deployment) DeploymentTracker {
+	return &emailDeployment{email: e, event: Event{Deployment: d}}
+}
+
+func (e Email) mail(ctx context.Context, subject, body string) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, emailTimeout)
+		defer cancel()
+	}
+	return e.sendMail(ctx, msg.Bytes())
+}
+
+// sendMail does what smtp.SendMail does, with a deadline from ctx on the
+// connection.
+func (e Email) sendMail(ctx context.Context, msg []byte) error {
+	host, _, err := net.SplitHostPort(e.SmtpAddress)
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", e.SmtpAddress)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if e.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.Username, e.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(e.From); err != nil {
+		return err
+	}
+	for _, to := range e.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func actionLinks(actions []Action) string {
+	var links []string
+	for _, action := range actions {
+		if action.Url != "" {
+			links = append(links, fmt.Sprintf("%s: %s", action.Text, action.Url))
+		}
+	}
+	return strings.Join(links, "\n")
+}
+
+// emailDeployment sends a single email with all steps and logs when the
+// deployment finishes.
+type emailDeployment struct {
+	email Email
+	event Event
+}
+
+func (d *emailDeployment) Progress(ctx context.Context, message string, progress int) error {
+	d.event.progress(message, progress)
+	return nil
+}
+
+func (d *emailDeployment) Log(ctx context.Context, message string) error {
+	d.event.log(message)
+	return nil
+}
+
+func (d *emailDeployment) Finish(ctx context.Context, message string, err error, actions []Action) error {
+	d.event.finish(message, err, actions)
+
+	deployment := d.event.Deployment
+	subject := fmt.Sprintf("[%s] %s: %s", deployment.Repo, deployment.Branch, message)
+	if err != nil {
+		subject = fmt.Sprintf("[%s] %s: Deployment failed", deployment.Repo, deployment.Branch)
+	}
+
+	return d.email.mail(ctx, subject, Render(d.email.Logger, TemplateEmail, d.event)+"\n")
+}
@@ -0,0 +1,119 @@
+package messenger
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubSmtpServer accepts a single email and sends its data on the returned channel.
+func stubSmtpServer(t *testing.T) (string, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := make(chan string, 1)
+
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		fmt.Fprint(conn, "220 localhost ESMTP\r\n")
+		var data []string
+		inData := false
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			if inData {
+				if line == "." {
+					inData = false
+					messages <- strings.Join(data, "\n")
+					fmt.Fprint(conn, "250 OK\r\n")
+				} else {
+					data = append(data, line)
+				}
+				continue
+			}
+
+			switch {
+			case strings.HasPrefix(line, "EHLO"), strings.HasPrefix(line, "HELO"):
+				fmt.Fprint(conn, "250 localhost\r\n")
+			case strings.HasPrefix(line, "DATA"):
+				inData = true
+				fmt.Fprint(conn, "354 Go ahead\r\n")
+			case strings.HasPrefix(line, "QUIT"):
+				fmt.Fprint(conn, "221 Bye\r\n")
+				return
+			default:
+				fmt.Fprint(conn, "250 OK\r\n")
+			}
+		}
+	}()
+
+	return listener.Addr().String(), messages
+}
+
+func TestEmail_TrackDeploymentSendsOneEmail(t *testing.T) {
+	address, messages := stubSmtpServer(t)
+	email := NewEmail(address, "staging@vektorprogrammet.no", []string{"admin@vektorprogrammet.no"})
+
+	ctx := context.Background()
+	tracker := email.TrackDeployment(Deployment{Repo: "vektorprogrammet/vektorprogrammet", Branch: "feature"})
+	tracker.Progress(ctx, "Cloning repository", 10)
+	tracker.Progress(ctx, "Installing composer and NPM dependencies", 30)
+	tracker.Log(ctx, "$ npm install\nnpm ERR! missing script")
+	if err := tracker.Finish(ctx, "Could not create staging server", errors.New("exit status 1"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	message := <-messages
+	for _, expected := range []string{
+		"Subject: [vektorprogrammet/vektorprogrammet] feature: Deployment failed",
+		"To: admin@vektorprogrammet.no",
+		" 30% Installing composer and NPM dependencies",
+		"npm ERR! missing script",
+	} {
+		if !strings.Contains(message, expected) {
+			t.Errorf("Expected email to contain %q, got:\n%s", expected, message)
+		}
+	}
+}
+
+func TestEmail_TimesOutOnHungServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+
+	email := NewEmail(listener.Addr().String(), "staging@vektorprogrammet.no", []string{"admin@vektorprogrammet.no"})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := email.Send(ctx, "Hello"); err == nil {
+		t.Error("Expected the email to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected to give up after the deadline, waited %s", elapsed)
+	}
+}
@@ -0,0 +1,117 @@
+package messenger
+
+import (
+	"context"
+	"path"
+)
+
+const (
+	EventMessage  = "message"
+	EventProgress = "progress"
+	EventSuccess  = "success"
+	EventFailure  = "failure"
+)
+
+// Filter selects which notifications a messenger receives. Empty lists
+// match everything, and repos and branches are matched as path patterns.
+type Filter struct {
+	Events   []string `json:"events"`
+	Repos    []string `json:"repos"`
+	Branches []string `json:"branches"`
+}
+
+func (f Filter) allowsEvent(event string) bool {
+	if len(f.Events) == 0 {
+		return true
+	}
+	for _, e := range f.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (f Filter) allowsDeployment(d Deployment) bool {
+	return matchesAny(f.Repos, d.Repo) && matchesAny(f.Branches, d.Branch)
+}
+
+func matchesAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type FilteredMessenger struct {
+	Messenger Messenger
+	Filter    Filter
+}
+
+func (f *FilteredMessenger) Send(ctx context.Context, message string) error {
+	if !f.Filter.allowsEvent(EventMessage) {
+		return nil
+	}
+	return f.Messenger.Send(ctx, message)
+}
+
+func (f *FilteredMessenger) SendWithActions(ctx context.Context, message string, actions []Action) error {
+	if !f.Filter.allowsEvent(EventMessage) {
+		return nil
+	}
+	return SendWithActions(ctx, f.Messenger, message, actions)
+}
+
+func (f *FilteredMessenger) TrackDeployment(d Deployment) DeploymentTracker {
+	if !f.Filter.allowsDeployment(d) {
+		return &filteredTracker{filter: Filter{Events: []string{}}}
+	}
+	return &filteredTracker{filter: f.Filter, tracker: Track(f.Messenger, d)}
+}
+
+// filteredTracker holds back logs until it knows whether the outcome of the
+// deployment passes the filter.
+type filteredTracker struct {
+	filter  Filter
+	tracker DeploymentTracker
+	logs    []string
+}
+
+func (t *filteredTracker) Progress(ctx context.Context, message string, progress int) error {
+	if t.tracker == nil || !t.filter.allowsEvent(EventProgress) {
+		return nil
+	}
+	return t.tracker.Progress(ctx, message, progress)
+}
+
+func (t *filteredTracker) Log(ctx context.Context, message string) error {
+	if t.tracker == nil {
+		return nil
+	}
+	t.logs = append(t.logs, message)
+	return nil
+}
+
+func (t *filteredTracker) Finish(ctx context.Context, message string, err error, actions []Action) error {
+	event := EventSuccess
+	if err != nil {
+		event = EventFailure
+	}
+	if t.tracker == nil || !t.filter.allowsEvent(event) {
+		t.logs = nil
+		return nil
+	}
+
+	for len(t.logs) > 0 {
+		if err := t.tracker.Log(ctx, t.logs[0]); err != nil {
+			return err
+		}
+		t.logs = t.logs[1:]
+	}
+	return t.tracker.Finish(ctx, message, err, actions)
+}
@@ -0,0 +1,48 @@
+package messenger
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestFilteredMessenger_OnlyFailures(t *testing.T) {
+	m := &flakyMessenger{}
+	filtered := &FilteredMessenger{Messenger: m, Filter: Filter{Events: []string{EventFailure}}}
+	ctx := context.Background()
+
+	succeeding := filtered.TrackDeployment(Deployment{Repo: "vektorprogrammet/vektorprogrammet", Branch: "feature"})
+	succeeding.Progress(ctx, "Cloning repository", 10)
+	succeeding.Finish(ctx, "Staging server deployed", nil, nil)
+
+	failing := filtered.TrackDeployment(Deployment{Repo: "vektorprogrammet/vektorprogrammet", Branch: "feature"})
+	failing.Progress(ctx, "Cloning repository", 10)
+	failing.Finish(ctx, "Could not create staging server", errors.New("exit status 1"), nil)
+
+	filtered.Send(ctx, "Failed to validate payload")
+
+	if len(m.sent) != 1 || m.sent[0] != "feature: Could not create staging server" {
+		t.Errorf("Expected only the failure to be sent, got %q", m.sent)
+	}
+}
+
+func TestFilteredMessenger_Branches(t *testing.T) {
+	m := &flakyMessenger{}
+	filtered := &FilteredMessenger{Messenger: m, Filter: Filter{
+		Repos:    []string{"vektorprogrammet/*"},
+		Branches: []string{"master", "release/*"},
+	}}
+	ctx := context.Background()
+
+	for _, d := range []Deployment{
+		{Repo: "vektorprogrammet/vektorprogrammet", Branch: "release/2018"},
+		{Repo: "vektorprogrammet/vektorprogrammet", Branch: "feature"},
+		{Repo: "another/repo", Branch: "master"},
+	} {
+		filtered.TrackDeployment(d).Finish(ctx, "Staging server deployed", nil, nil)
+	}
+
+	if len(m.sent) != 1 || m.sent[0] != "release/2018: Staging server deployed" {
+		t.Errorf("Expected only release/2018 to be sent, got %q", m.sent)
+	}
+}
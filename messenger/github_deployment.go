@@ -0,0 +1,168 @@
+package messenger
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-github/github"
+	"github.com/vektorprogrammet/build-system/githubclient"
+)
+
+const commitStatusContext = "staging"
+
+type GithubDeployment struct {
+	Clients githubclient.Factory
+	Owner   string
+	Repo    string
+	Branch  string
+	Sha     string
+	Id      int64
+}
+
+func NewGithubDeployment(clients githubclient.Factory, branch, sha string) *GithubDeployment {
+	return &GithubDeployment{
+		Clients: clients,
+		Owner:   githubclient.DefaultOwner,
+		Repo:    githubclient.DefaultRepo,
+		Branch:  branch,
+		Sha:     sha,
+	}
+}
+
+func (g *GithubDeployment) createClient() (*github.Client, context.Context, error) {
+	ctx := context.Background()
+	client, err := g.Clients.Client(ctx, g.Owner, g.Repo)
+
+	return client, ctx, err
+}
+
+func (g *GithubDeployment) Environment() string {
+	return "staging/" + g.Branch
+}
+
+func (g *GithubDeployment) Start(description string) error {
+	client, ctx, err := g.createClient()
+	if err != nil {
+		return err
+	}
+
+	if g.Sha == "" {
+		sha, _, err := client.Repositories.GetCommitSHA1(ctx, g.Owner, g.Repo, g.Branch, "")
+		if err != nil {
+			return err
+		}
+		g.Sha = sha
+	}
+
+	deployment, _, err := client.Repositories.CreateDeployment(ctx, g.Owner, g.Repo, &github.DeploymentRequest{
+		Ref:                  github.String(g.Sha),
+		Environment:          github.String(g.Environment()),
+		Description:          github.String(description),
+		AutoMerge:            github.Bool(false),
+		RequiredContexts:     &[]string{},
+		TransientEnvironment: github.Bool(true),
+	})
+	if err != nil {
+		return err
+	}
+	g.Id = deployment.GetID()
+
+	if err := g.setDeploymentStatus("in_progress", description, "", ""); err != nil {
+		return err
+	}
+	return g.setCommitStatus("pending", description, "")
+}
+
+func (g *GithubDeployment) Succeed(environmentUrl, logUrl string) error {
+	if err := g.setDeploymentStatus("success", "Staging server is ready", environmentUrl, logUrl); err != nil {
+		return err
+	}
+	return g.setCommitStatus("success", "Staging server is ready", environmentUrl)
+}
+
+func (g *GithubDeployment) Fail(logUrl string, cause error) error {
+	description := fmt.Sprintf("Deploy failed: %s", cause)
+	if err := g.setDeploymentStatus("failure", description, "", logUrl); err != nil {
+		return err
+	}
+	return g.setCommitStatus("failure", description, logUrl)
+}
+
+func (g *GithubDeployment) Deactivate() error {
+	client, ctx, err := g.createClient()
+	if err != nil {
+		return err
+	}
+
+	opt := &github.DeploymentsListOptions{
+		Environment: g.Environment(),
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	var deployments []*github.Deployment
+	for {
+		page, resp, err := client.Repositories.ListDeployments(ctx, g.Owner, g.Repo, opt)
+		if err != nil {
+			return err
+		}
+		deployments = append(deployments, page...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+
+	for _, deployment := range deployments {
+		g.Id = deployment.GetID()
+		if err := g.setDeploymentStatus("inactive", "Staging server removed", "", ""); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (g *GithubDeployment) setDeploymentStatus(state, description, environmentUrl, logUrl string) error {
+	if g.Id == 0 {
+		return nil
+	}
+	client, ctx, err := g.createClient()
+	if err != nil {
+		return err
+	}
+
+	request := &github.DeploymentStatusRequest{
+		State:       github.String(state),
+		Description: github.String(truncate(description, 140)),
+	}
+	if environmentUrl != "" {
+		request.EnvironmentURL = github.String(environmentUrl)
+	}
+	if logUrl != "" {
+		request.LogURL = github.String(logUrl)
+	}
+
+	_, _, err = client.Repositories.CreateDeploymentStatus(ctx, g.Owner, g.Repo, g.Id, request)
+	return err
+}
+
+func (g *GithubDeployment) setCommitStatus(state, description, targetUrl string) error {
+	if g.Sha == "" {
+		return nil
+	}
+	client, ctx, err := g.createClient()
+	if err != nil {
+		return err
+	}
+
+	status := &github.RepoStatus{
+		State:       github.String(state),
+		Description: github.String(truncate(description, 140)),
+		Context:     github.String(commitStatusContext),
+	}
+	if targetUrl != "" {
+		status.TargetURL = github.String(targetUrl)
+	}
+
+	_, _, err = client.Repositories.CreateStatus(ctx, g.Owner, g.Repo, g.Sha, status)
+	return err
+}
@@ -0,0 +1,44 @@
+package messenger
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/vektorprogrammet/build-system/githubclient"
+)
+
+func TestGithubDeployment_DeactivateAllPages(t *testing.T) {
+	var deactivated []string
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/repos/vektorprogrammet/vektorprogrammet/deployments":
+			if r.URL.Query().Get("page") == "2" {
+				fmt.Fprint(w, `[{"id":3}]`)
+				return
+			}
+			w.Header().Set("Link", fmt.Sprintf(`<%s/repos/vektorprogrammet/vektorprogrammet/deployments?page=2>; rel="next"`, server.URL))
+			fmt.Fprint(w, `[{"id":1},{"id":2}]`)
+		case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/statuses"):
+			deactivated = append(deactivated, strings.Split(r.URL.Path, "/")[5])
+			fmt.Fprint(w, `{}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	deployment := NewGithubDeployment(&githubclient.TokenFactory{BaseURL: server.URL + "/"}, "feature", "")
+	if err := deployment.Deactivate(); err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(deactivated)
+	if strings.Join(deactivated, ",") != "1,2,3" {
+		t.Errorf("Expected deployments 1, 2 and 3 to be deactivated, got %v", deactivated)
+	}
+}
@@ -2,37 +2,49 @@ package messenger
 
 import (
 	"context"
-	"fmt"
-	"os"
+	"log/slog"
 
 	"github.com/google/go-github/github"
-	"golang.org/x/oauth2"
+	"github.com/vektorprogrammet/build-system/githubclient"
 )
 
 type GithubCommenter struct {
+	Clients           githubclient.Factory
+	Owner             string
+	Repo              string
 	ProgressCommentId int64
 	PrNumber          int
+	// Logger defaults to slog.Default.
+	Logger *slog.Logger
 }
 
-func (g *GithubCommenter) createClient() (*github.Client, context.Context) {
+func NewGithubCommenter(clients githubclient.Factory, prNumber int) *GithubCommenter {
+	return &GithubCommenter{
+		Clients:  clients,
+		Owner:    githubclient.DefaultOwner,
+		Repo:     githubclient.DefaultRepo,
+		PrNumber: prNumber,
+	}
+}
+
+func (g *GithubCommenter) createClient() (*github.Client, context.Context, error) {
 	ctx := context.Background()
-	accessToken := os.Getenv("GITHUB_ACCESS_TOKEN")
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: accessToken},
-	)
-	tc := oauth2.NewClient(ctx, ts)
+	client, err := g.Clients.Client(ctx, g.Owner, g.Repo)
 
-	return github.NewClient(tc), ctx
+	return client, ctx, err
 }
 
 func (g *GithubCommenter) Comment(comment string) (*github.IssueComment, error) {
-	client, ctx := g.createClient()
+	client, ctx, err := g.createClient()
+	if err != nil {
+		return nil, err
+	}
 
 	prComment := github.IssueComment{
 		Body: &comment,
 	}
 
-	issue, _, err := client.Issues.CreateComment(ctx, "vektorprogrammet", "vektorprogrammet", g.PrNumber, &prComment)
+	issue, _, err := client.Issues.CreateComment(ctx, g.Owner, g.Repo, g.PrNumber, &prComment)
 	if err != nil {
 		return nil, err
 	}
@@ -41,13 +53,16 @@ func (g *GithubCommenter) Comment(comment string) (*github.IssueComment, error)
 }
 
 func (g *GithubCommenter) EditComment(id int64, comment string) (*github.IssueComment, error) {
-	client, ctx := g.createClient()
+	client, ctx, err := g.createClient()
+	if err != nil {
+		return nil, err
+	}
 
 	prComment := github.IssueComment{
 		Body: &comment,
 	}
 
-	issue, _, err := client.Issues.EditComment(ctx, "vektorprogrammet", "vektorprogrammet", id, &prComment)
+	issue, _, err := client.Issues.EditComment(ctx, g.Owner, g.Repo, id, &prComment)
 	if err != nil {
 		return nil, err
 	}
@@ -56,9 +71,12 @@ func (g *GithubCommenter) EditComment(id int64, comment string) (*github.IssueCo
 }
 
 func (g *GithubCommenter) DeleteComment(id int64) error {
-	client, ctx := g.createClient()
+	client, ctx, err := g.createClient()
+	if err != nil {
+		return err
+	}
 
-	_, err := client.Issues.DeleteComment(ctx, "vektorprogrammet", "vektorprogrammet", id)
+	_, err = client.Issues.DeleteComment(ctx, g.Owner, g.Repo, id)
 	if err != nil {
 		return err
 	}
@@ -66,16 +84,43 @@ func (g *GithubCommenter) DeleteComment(id int64) error {
 	return nil
 }
 
-func (g *GithubCommenter) StartingDeploy() {
-	issueComment, _ := g.Comment("Starting deploy to staging server...")
-	g.ProgressCommentId = *issueComment.ID
+// TrackDeployment keeps a single pull request comment up to date with the
+// progress, result and logs of a deployment.
+func (g *GithubCommenter) TrackDeployment(d Deployment) DeploymentTracker {
+	return &githubCommentTracker{commenter: g, event: Event{Deployment: d}}
+}
+
+type githubCommentTracker struct {
+	commenter *GithubCommenter
+	event     Event
+}
+
+func (t *githubCommentTracker) Progress(ctx context.Context, message string, progress int) error {
+	t.event.progress(message, progress)
+	return t.update()
 }
 
-func (g *GithubCommenter) UpdateProgress(message string, progress int) {
-	comment := fmt.Sprintf("Deploying this pull request to the staging server... %d %%\n%s", progress, message)
-	g.EditComment(g.ProgressCommentId, comment)
+func (t *githubCommentTracker) Log(ctx context.Context, message string) error {
+	t.event.log(message)
+	return nil
+}
+
+func (t *githubCommentTracker) Finish(ctx context.Context, message string, err error, actions []Action) error {
+	t.event.finish(message, err, actions)
+	return t.update()
 }
 
-func (g *GithubCommenter) Delete() {
-	g.DeleteComment(g.ProgressCommentId)
+func (t *githubCommentTracker) update() error {
+	comment := Render(t.commenter.Logger, TemplateGithub, t.event)
+	if t.commenter.ProgressCommentId == 0 {
+		issueComment, err := t.commenter.Comment(comment)
+		if err != nil {
+			return err
+		}
+		t.commenter.ProgressCommentId = *issueComment.ID
+		return nil
+	}
+
+	_, err := t.commenter.EditComment(t.commenter.ProgressCommentId, comment)
+	return err
 }
@@ -0,0 +1,65 @@
+package messenger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// postJson posts payload to url and turns rate limits and unsuccessful
+// responses into errors the outbox can retry.
+func postJson(ctx context.Context, url string, payload interface{}, headers func(body []byte) map[string]string) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if headers != nil {
+		for name, value := range headers(body) {
+			req.Header.Set(name, value)
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		seconds, _ := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64)
+		return &RetryAfterError{
+			After: time.Duration(seconds * float64(time.Second)),
+			Err:   errors.New("rate limited"),
+		}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s from %s", resp.Status, req.URL.Host)
+	}
+
+	return nil
+}
+
+// truncate shortens s to at most length bytes without splitting a character.
+func truncate(s string, length int) string {
+	if len(s) <= length {
+		return s
+	}
+	end := length - 3
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+	return s[:end] + "..."
+}
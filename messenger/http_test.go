@@ -0,0 +1,24 @@
+package messenger
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateKeepsCharactersWhole(t *testing.T) {
+	for _, test := range []struct {
+		s        string
+		length   int
+		expected string
+	}{
+		{"short", 10, "short"},
+		{"Deploy failed: exit status 1", 10, "Deploy ..."},
+		{"Kunne ikke oppdatere æøå", 25, "Kunne ikke oppdatere ..."},
+		{"Kunne ikke oppdatere æøå", 26, "Kunne ikke oppdatere æ..."},
+	} {
+		actual := truncate(test.s, test.length)
+		if actual != test.expected || !utf8.ValidString(actual) || len(actual) > test.length {
+			t.Errorf("truncate(%q, %d): Expected %q, got %q", test.s, test.length, test.expected, actual)
+		}
+	}
+}
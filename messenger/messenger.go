@@ -1,5 +1,78 @@
 package messenger
 
+import (
+	"context"
+)
+
 type Messenger interface {
-	Send(message string)
+	Send(ctx context.Context, message string) error
+}
+
+type Action struct {
+	Id        string `json:"id"`
+	Text      string `json:"text"`
+	Url       string `json:"url,omitempty"`
+	Value     string `json:"value,omitempty"`
+	Dangerous bool   `json:"dangerous,omitempty"`
+}
+
+type ActionMessenger interface {
+	Messenger
+	SendWithActions(ctx context.Context, message string, actions []Action) error
+}
+
+// DeploymentTracker reports a single deployment, for messengers that can
+// update a message in place instead of sending one message per step.
+// Calls must be safe to retry.
+type DeploymentTracker interface {
+	Progress(ctx context.Context, message string, progress int) error
+	Log(ctx context.Context, message string) error
+	Finish(ctx context.Context, message string, err error, actions []Action) error
+}
+
+type Deployment struct {
+	Repo   string
+	Branch string
+	// LogUrl links to the deploy log, which requires signing in.
+	LogUrl string
+}
+
+type TrackingMessenger interface {
+	Messenger
+	TrackDeployment(d Deployment) DeploymentTracker
+}
+
+// Track returns a tracker for m, falling back to one message per call for
+// messengers that can not update messages.
+func Track(m Messenger, d Deployment) DeploymentTracker {
+	if trackingMessenger, ok := m.(TrackingMessenger); ok {
+		return trackingMessenger.TrackDeployment(d)
+	}
+	return &messageTracker{messenger: m, event: Event{Deployment: d}}
+}
+
+func SendWithActions(ctx context.Context, m Messenger, message string, actions []Action) error {
+	if actionMessenger, ok := m.(ActionMessenger); ok && len(actions) > 0 {
+		return actionMessenger.SendWithActions(ctx, message, actions)
+	}
+	return m.Send(ctx, message)
+}
+
+type messageTracker struct {
+	messenger Messenger
+	event     Event
+}
+
+func (t *messageTracker) Progress(ctx context.Context, message string, progress int) error {
+	t.event.progress(message, progress)
+	return t.messenger.Send(ctx, Render(nil, TemplateText, t.event))
+}
+
+func (t *messageTracker) Log(ctx context.Context, message string) error {
+	return nil
+}
+
+func (t *messageTracker) Finish(ctx context.Context, message string, err error, actions []Action) error {
+	t.event.finish(message, err, actions)
+	return SendWithActions(ctx, t.messenger, Render(nil, TemplateText, t.event), actions)
 }
@@ -0,0 +1,86 @@
+package messenger
+
+import (
+	"context"
+	"strings"
+)
+
+// MultiMessenger sends every message to all of its messengers.
+type MultiMessenger []Messenger
+
+type multiError []error
+
+func (m multiError) Error() string {
+	var messages []string
+	for _, err := range m {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (m MultiMessenger) Send(ctx context.Context, message string) error {
+	var errs multiError
+	for _, messenger := range m {
+		if err := messenger.Send(ctx, message); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.orNil()
+}
+
+func (m MultiMessenger) SendWithActions(ctx context.Context, message string, actions []Action) error {
+	var errs multiError
+	for _, messenger := range m {
+		if err := SendWithActions(ctx, messenger, message, actions); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.orNil()
+}
+
+func (m MultiMessenger) TrackDeployment(d Deployment) DeploymentTracker {
+	var trackers multiTracker
+	for _, messenger := range m {
+		trackers = append(trackers, Track(messenger, d))
+	}
+	return trackers
+}
+
+type multiTracker []DeploymentTracker
+
+func (m multiTracker) Progress(ctx context.Context, message string, progress int) error {
+	var errs multiError
+	for _, tracker := range m {
+		if err := tracker.Progress(ctx, message, progress); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.orNil()
+}
+
+func (m multiTracker) Log(ctx context.Context, message string) error {
+	var errs multiError
+	for _, tracker := range m {
+		if err := tracker.Log(ctx, message); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.orNil()
+}
+
+func (m multiTracker) Finish(ctx context.Context, message string, err error, actions []Action) error {
+	var errs multiError
+	for _, tracker := range m {
+		if err := tracker.Finish(ctx, message, err, actions); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.orNil()
+}
+
+func (m multiError) orNil() error {
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
@@ -0,0 +1,215 @@
+package messenger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var ErrOutboxFull = errors.New("outbox is full")
+
+// RetryAfterError is returned by messengers that are rate limited, and makes
+// the outbox wait the requested time before retrying.
+type RetryAfterError struct {
+	After time.Duration
+	Err   error
+}
+
+func (e *RetryAfterError) Error() string {
+	return fmt.Sprintf("%s (retry after %s)", e.Err, e.After)
+}
+
+// PermanentError is returned by messengers when retrying can not help, or
+// could deliver the message twice.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+type delivery struct {
+	description string
+	send        func(ctx context.Context) error
+}
+
+// Outbox delivers messages asynchronously, in order, retrying failed
+// deliveries with exponential backoff. Deliveries that still fail are logged
+// and written to DeadLetters if it is set.
+type Outbox struct {
+	Messenger   Messenger
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	DeadLetters io.Writer
+	// Logger defaults to slog.Default.
+	Logger *slog.Logger
+
+	queue chan delivery
+	done  chan struct{}
+	once  sync.Once
+}
+
+func NewOutbox(m Messenger) *Outbox {
+	return &Outbox{
+		Messenger:   m,
+		MaxAttempts: 5,
+		BaseDelay:   time.Second,
+		MaxDelay:    time.Minute,
+		queue:       make(chan delivery, 1000),
+		done:        make(chan struct{}),
+	}
+}
+
+func (o *Outbox) Start() {
+	go func() {
+		defer close(o.done)
+		for d := range o.queue {
+			o.deliver(d)
+		}
+	}()
+}
+
+// Close stops accepting messages and waits until the queued ones are delivered.
+func (o *Outbox) Close() {
+	o.once.Do(func() {
+		close(o.queue)
+	})
+	<-o.done
+}
+
+func (o *Outbox) Send(ctx context.Context, message string) error {
+	return o.enqueue(message, func(ctx context.Context) error {
+		return o.Messenger.Send(ctx, message)
+	})
+}
+
+func (o *Outbox) SendWithActions(ctx context.Context, message string, actions []Action) error {
+	return o.enqueue(message, func(ctx context.Context) error {
+		return SendWithActions(ctx, o.Messenger, message, actions)
+	})
+}
+
+func (o *Outbox) TrackDeployment(d Deployment) DeploymentTracker {
+	return &outboxTracker{outbox: o, deployment: d, tracker: Track(o.Messenger, d)}
+}
+
+func (o *Outbox) enqueue(description string, send func(ctx context.Context) error) error {
+	select {
+	case o.queue <- delivery{description: description, send: send}:
+		return nil
+	default:
+		o.deadLetter(description, ErrOutboxFull, 0)
+		return ErrOutboxFull
+	}
+}
+
+func (o *Outbox) deliver(d delivery) {
+	var err error
+	attempt := 1
+	for ; attempt <= o.MaxAttempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err = d.send(ctx)
+		cancel()
+		if err == nil {
+			return
+		}
+		if _, permanent := err.(*PermanentError); permanent || attempt == o.MaxAttempts {
+			break
+		}
+
+		delay := o.backoff(attempt)
+		if retryAfter, ok := err.(*RetryAfterError); ok && retryAfter.After > delay {
+			delay = retryAfter.After
+		}
+		o.logger().Warn("Could not deliver message, retrying",
+			"attempt", attempt,
+			"max_attempts", o.MaxAttempts,
+			"delay", delay.String(),
+			"error", err,
+		)
+		time.Sleep(delay)
+	}
+
+	o.deadLetter(d.description, err, attempt)
+}
+
+func (o *Outbox) backoff(attempt int) time.Duration {
+	delay := o.BaseDelay << uint(attempt-1)
+	if delay > o.MaxDelay || delay <= 0 {
+		return o.MaxDelay
+	}
+	return delay
+}
+
+var notificationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "staging_notification_failures_total",
+	Help: "Notifications that could not be delivered.",
+}, []string{"reason"})
+
+func (o *Outbox) deadLetter(message string, err error, attempts int) {
+	if err == ErrOutboxFull {
+		notificationFailures.WithLabelValues("outbox_full").Inc()
+	} else {
+		notificationFailures.WithLabelValues("failed").Inc()
+	}
+	o.logger().Error("Could not deliver message", "message", message, "attempts", attempts, "error", err)
+	if o.DeadLetters == nil {
+		return
+	}
+
+	entry, _ := json.Marshal(struct {
+		Time     time.Time `json:"time"`
+		Message  string    `json:"message"`
+		Error    string    `json:"error"`
+		Attempts int       `json:"attempts"`
+	}{time.Now(), message, err.Error(), attempts})
+	fmt.Fprintf(o.DeadLetters, "Dead letter: %s\n", entry)
+}
+
+func (o *Outbox) logger() *slog.Logger {
+	if o.Logger == nil {
+		return slog.Default()
+	}
+	return o.Logger
+}
+
+type outboxTracker struct {
+	outbox     *Outbox
+	deployment Deployment
+	tracker    DeploymentTracker
+}
+
+func (t *outboxTracker) describe(message string, progress int) string {
+	return Render(t.outbox.logger(), TemplateText, Event{Type: EventProgress, Deployment: t.deployment, Message: message, Progress: progress})
+}
+
+func (t *outboxTracker) Progress(ctx context.Context, message string, progress int) error {
+	return t.outbox.enqueue(t.describe(message, progress), func(ctx context.Context) error {
+		return t.tracker.Progress(ctx, message, progress)
+	})
+}
+
+func (t *outboxTracker) Log(ctx context.Context, message string) error {
+	return t.outbox.enqueue(t.deployment.Branch+": "+message, func(ctx context.Context) error {
+		return t.tracker.Log(ctx, message)
+	})
+}
+
+func (t *outboxTracker) Finish(ctx context.Context, message string, err error, actions []Action) error {
+	event := Event{Deployment: t.deployment}
+	event.finish(message, err, actions)
+	description := Render(t.outbox.logger(), TemplateText, event)
+	return t.outbox.enqueue(description, func(ctx context.Context) error {
+		return t.tracker.Finish(ctx, message, err, actions)
+	})
+}
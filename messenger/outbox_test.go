@@ -0,0 +1,135 @@
+package messenger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+type flakyMessenger struct {
+	failures []error
+	attempts int
+	sent     []string
+}
+
+func (f *flakyMessenger) Send(ctx context.Context, message string) error {
+	f.attempts++
+	if len(f.failures) > 0 {
+		err := f.failures[0]
+		f.failures = f.failures[1:]
+		return err
+	}
+	f.sent = append(f.sent, message)
+	return nil
+}
+
+func newTestOutbox(m Messenger) (*Outbox, *bytes.Buffer) {
+	deadLetters := &bytes.Buffer{}
+	outbox := NewOutbox(m)
+	outbox.MaxAttempts = 3
+	outbox.BaseDelay = time.Millisecond
+	outbox.MaxDelay = 10 * time.Millisecond
+	outbox.DeadLetters = deadLetters
+	outbox.Start()
+	return outbox, deadLetters
+}
+
+func TestOutbox_RetriesFailedDeliveries(t *testing.T) {
+	m := &flakyMessenger{failures: []error{errors.New("timeout"), errors.New("timeout")}}
+	outbox, deadLetters := newTestOutbox(m)
+
+	outbox.Send(context.Background(), "first")
+	outbox.Send(context.Background(), "second")
+	outbox.Close()
+
+	if strings.Join(m.sent, ",") != "first,second" {
+		t.Errorf("Expected messages to be delivered in order, got %q", m.sent)
+	}
+	if m.attempts != 4 {
+		t.Errorf("Expected 4 attempts, got %d", m.attempts)
+	}
+	if deadLetters.Len() != 0 {
+		t.Errorf("Expected no dead letters, got %q", deadLetters.String())
+	}
+}
+
+func TestOutbox_HonoursRetryAfter(t *testing.T) {
+	m := &flakyMessenger{failures: []error{&RetryAfterError{After: 50 * time.Millisecond, Err: errors.New("rate limited")}}}
+	outbox, _ := newTestOutbox(m)
+
+	start := time.Now()
+	outbox.Send(context.Background(), "message")
+	outbox.Close()
+
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected to wait at least 50ms before retrying, waited %s", elapsed)
+	}
+	if len(m.sent) != 1 {
+		t.Errorf("Expected message to be delivered, got %q", m.sent)
+	}
+}
+
+func TestOutbox_DoesNotRetryPermanentErrors(t *testing.T) {
+	m := &flakyMessenger{failures: []error{&PermanentError{Err: errors.New("could not decode response")}}}
+	outbox, deadLetters := newTestOutbox(m)
+
+	outbox.Send(context.Background(), "posted once")
+	outbox.Close()
+
+	if m.attempts != 1 {
+		t.Errorf("Expected 1 attempt, got %d", m.attempts)
+	}
+	if !strings.Contains(deadLetters.String(), "posted once") {
+		t.Errorf("Expected a dead letter, got %q", deadLetters.String())
+	}
+}
+
+func TestOutbox_DeadLetters(t *testing.T) {
+	m := &flakyMessenger{failures: []error{errors.New("down"), errors.New("down"), errors.New("down")}}
+	outbox, deadLetters := newTestOutbox(m)
+
+	outbox.Send(context.Background(), "lost message")
+	outbox.Close()
+
+	if m.attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", m.attempts)
+	}
+	if !strings.Contains(deadLetters.String(), "lost message") || !strings.Contains(deadLetters.String(), "down") {
+		t.Errorf("Expected dead letter with message and error, got %q", deadLetters.String())
+	}
+}
+
+func TestOutbox_DeadLettersFailedDeployments(t *testing.T) {
+	m := &flakyMessenger{failures: []error{errors.New("down"), errors.New("down"), errors.New("down")}}
+	outbox, deadLetters := newTestOutbox(m)
+	loaded := DefaultTemplates()
+	loaded[TemplateText] = template.Must(template.New(TemplateText).Parse("{{.Type}} {{.Deployment.Branch}}: {{.Error}}"))
+	UseTemplates(loaded)
+	defer UseTemplates(DefaultTemplates())
+
+	outbox.TrackDeployment(Deployment{Branch: "feature"}).Finish(context.Background(), "Could not create staging server", errors.New("exit status 1"), nil)
+	outbox.Close()
+
+	if !strings.Contains(deadLetters.String(), "failure feature: exit status 1") {
+		t.Errorf("Expected the dead letter to describe a failure, got %q", deadLetters.String())
+	}
+}
+
+func TestMultiMessenger_Send(t *testing.T) {
+	first := &flakyMessenger{}
+	failing := &flakyMessenger{failures: []error{errors.New("down")}}
+	last := &flakyMessenger{}
+
+	err := MultiMessenger{first, failing, last}.Send(context.Background(), "message")
+
+	if err == nil || err.Error() != "down" {
+		t.Errorf("Expected error from failing messenger, got %v", err)
+	}
+	if len(first.sent) != 1 || len(last.sent) != 1 {
+		t.Error("Expected message to be sent to all messengers")
+	}
+}
@@ -1,40 +1,280 @@
 package messenger
 
 import (
-	"net/http"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
+const DefaultSlackApiUrl = "https://slack.com/api"
+
 type Slack struct {
-	Endpoint  string `json:"endpoint"`
+	Token     string `json:"token"`
+	ApiUrl    string `json:"api_url"`
 	Channel   string `json:"channel"`
 	Username  string `json:"username"`
 	IconEmoji string `json:"icon_emoji"`
+	// Logger defaults to slog.Default.
+	Logger *slog.Logger `json:"-"`
 }
 
 type slackMessage struct {
-	Channel   string `json:"channel"`
-	Text      string `json:"text"`
-	Username  string `json:"username"`
-	IconEmoji string `json:"icon_emoji"`
+	Channel   string       `json:"channel"`
+	Text      string       `json:"text"`
+	Username  string       `json:"username,omitempty"`
+	IconEmoji string       `json:"icon_emoji,omitempty"`
+	Blocks    []slackBlock `json:"blocks,omitempty"`
+	Ts        string       `json:"ts,omitempty"`
+	ThreadTs  string       `json:"thread_ts,omitempty"`
+}
+
+type slackApiResponse struct {
+	Ok      bool   `json:"ok"`
+	Error   string `json:"error"`
+	Channel string `json:"channel"`
+	Ts      string `json:"ts"`
+}
+
+type slackBlock struct {
+	Type     string         `json:"type"`
+	Text     *slackText     `json:"text,omitempty"`
+	Elements []slackElement `json:"elements,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackElement struct {
+	Type     string        `json:"type"`
+	Text     slackText     `json:"text"`
+	ActionId string        `json:"action_id"`
+	Url      string        `json:"url,omitempty"`
+	Value    string        `json:"value,omitempty"`
+	Style    string        `json:"style,omitempty"`
+	Confirm  *slackConfirm `json:"confirm,omitempty"`
+}
+
+type slackConfirm struct {
+	Title   slackText `json:"title"`
+	Text    slackText `json:"text"`
+	Confirm slackText `json:"confirm"`
+	Deny    slackText `json:"deny"`
+}
+
+func (s Slack) Send(ctx context.Context, message string) error {
+	_, err := s.postMessage(ctx, slackMessage{Text: message})
+	return err
 }
 
-func (s Slack) Send(message string) {
-	jsonData, _ := json.Marshal(slackMessage{
-		Channel:   s.Channel,
-		Text:      message,
-		Username:  s.Username,
-		IconEmoji: s.IconEmoji,
+func (s Slack) SendWithActions(ctx context.Context, message string, actions []Action) error {
+	_, err := s.postMessage(ctx, slackMessage{
+		Text: message,
+		Blocks: []slackBlock{
+			{Type: "section", Text: &slackText{Type: "mrkdwn", Text: message}},
+			slackActions(actions),
+		},
 	})
-	http.Post(s.Endpoint, "application/json", bytes.NewBuffer(jsonData))
+	return err
+}
+
+func (s Slack) TrackDeployment(d Deployment) DeploymentTracker {
+	return &slackDeployment{slack: s, event: Event{Deployment: d}}
+}
+
+func (s Slack) postMessage(ctx context.Context, message slackMessage) (slackApiResponse, error) {
+	message.Channel = s.Channel
+	message.Username = s.Username
+	message.IconEmoji = s.IconEmoji
+	return s.call(ctx, "chat.postMessage", message)
+}
+
+func (s Slack) updateMessage(ctx context.Context, channel, ts string, message slackMessage) (slackApiResponse, error) {
+	message.Channel = channel
+	message.Ts = ts
+	return s.call(ctx, "chat.update", message)
+}
+
+func (s Slack) call(ctx context.Context, method string, message slackMessage) (slackApiResponse, error) {
+	var response slackApiResponse
+
+	jsonData, err := json.Marshal(message)
+	if err != nil {
+		return response, err
+	}
+
+	apiUrl := s.ApiUrl
+	if apiUrl == "" {
+		apiUrl = DefaultSlackApiUrl
+	}
+	req, err := http.NewRequest("POST", apiUrl+"/"+method, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return response, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+s.Token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return response, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return response, &RetryAfterError{
+			After: time.Duration(seconds) * time.Second,
+			Err:   errors.New(method + ": rate limited"),
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return response, fmt.Errorf("%s: unexpected status %s", method, resp.Status)
+	}
+
+	// Slack has posted the message if it answered 200, so it must not be
+	// retried even if the response can not be read.
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return response, &PermanentError{Err: fmt.Errorf("%s: could not decode response: %s", method, err)}
+	}
+	if !response.Ok {
+		err := errors.New(method + ": " + response.Error)
+		if slackPermanentErrors[response.Error] {
+			return response, &PermanentError{Err: err}
+		}
+		return response, err
+	}
+
+	return response, nil
+}
+
+// slackPermanentErrors are errors from the Slack API that retrying does not
+// fix, like a wrong token or a channel the bot is not in.
+var slackPermanentErrors = map[string]bool{
+	"not_authed":        true,
+	"invalid_auth":      true,
+	"account_inactive":  true,
+	"token_revoked":     true,
+	"missing_scope":     true,
+	"channel_not_found": true,
+	"not_in_channel":    true,
+	"is_archived":       true,
+	"invalid_blocks":    true,
+}
+
+func slackActions(actions []Action) slackBlock {
+	var elements []slackElement
+	for _, action := range actions {
+		element := slackElement{
+			Type:     "button",
+			Text:     slackText{Type: "plain_text", Text: action.Text},
+			ActionId: action.Id,
+			Url:      action.Url,
+			Value:    action.Value,
+		}
+		if action.Dangerous {
+			element.Style = "danger"
+			element.Confirm = &slackConfirm{
+				Title:   slackText{Type: "plain_text", Text: "Are you sure?"},
+				Text:    slackText{Type: "mrkdwn", Text: action.Text + " " + action.Value + "?"},
+				Confirm: slackText{Type: "plain_text", Text: action.Text},
+				Deny:    slackText{Type: "plain_text", Text: "Cancel"},
+			}
+		}
+		elements = append(elements, element)
+	}
+
+	return slackBlock{Type: "actions", Elements: elements}
 }
 
-func NewSlack(endpoint, channel, username, iconEmoji string) Slack {
+func NewSlack(token, channel, username, iconEmoji string) Slack {
 	return Slack{
+		Token:     token,
+		ApiUrl:    DefaultSlackApiUrl,
 		Channel:   channel,
-		Endpoint:  endpoint,
 		Username:  username,
 		IconEmoji: iconEmoji,
 	}
 }
+
+type slackDeployment struct {
+	slack   Slack
+	channel string
+	ts      string
+	event   Event
+}
+
+func (d *slackDeployment) Progress(ctx context.Context, message string, progress int) error {
+	d.event.progress(message, progress)
+	return d.update(ctx)
+}
+
+func (d *slackDeployment) Log(ctx context.Context, message string) error {
+	if d.ts == "" {
+		if err := d.update(ctx); err != nil {
+			return err
+		}
+	}
+
+	_, err := d.slack.postMessage(ctx, slackMessage{Text: message, ThreadTs: d.ts})
+	return err
+}
+
+func (d *slackDeployment) Finish(ctx context.Context, message string, err error, actions []Action) error {
+	d.event.finish(message, err, actions)
+	return d.update(ctx)
+}
+
+func (d *slackDeployment) update(ctx context.Context) error {
+	message := slackMessage{
+		Text:   d.event.Deployment.Branch,
+		Blocks: d.blocks(),
+	}
+
+	if d.ts == "" {
+		response, err := d.slack.postMessage(ctx, message)
+		if err != nil {
+			return err
+		}
+		d.channel = response.Channel
+		d.ts = response.Ts
+		return nil
+	}
+
+	_, err := d.slack.updateMessage(ctx, d.channel, d.ts, message)
+	return err
+}
+
+func (d *slackDeployment) blocks() []slackBlock {
+	// Paragraphs of the rendered template become separate sections.
+	var blocks []slackBlock
+	for _, section := range strings.Split(Render(d.slack.Logger, TemplateSlack, d.event), "\n\n") {
+		if section = strings.TrimSpace(section); section != "" {
+			blocks = append(blocks, slackBlock{Type: "section", Text: &slackText{Type: "mrkdwn", Text: section}})
+		}
+	}
+	if len(d.event.Actions) > 0 {
+		blocks = append(blocks, slackActions(d.event.Actions))
+	}
+	return blocks
+}
+
+func progressBar(progress int) string {
+	const width = 20
+	filled := progress * width / 100
+	if filled < 0 {
+		filled = 0
+	}
+	if filled > width {
+		filled = width
+	}
+	return strings.Repeat("█", filled) + strings.Repeat("░", width-filled)
+}
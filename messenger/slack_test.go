@@ -0,0 +1,154 @@
+package messenger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type slackCall struct {
+	method  string
+	message slackMessage
+}
+
+func newStubSlackApi(calls *[]slackCall) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer bot-token" {
+			fmt.Fprint(w, `{"ok":false,"error":"not_authed"}`)
+			return
+		}
+
+		var message slackMessage
+		json.NewDecoder(r.Body).Decode(&message)
+		*calls = append(*calls, slackCall{method: strings.TrimPrefix(r.URL.Path, "/"), message: message})
+
+		ts := message.Ts
+		if ts == "" {
+			ts = fmt.Sprintf("1000.%d", len(*calls))
+		}
+		fmt.Fprintf(w, `{"ok":true,"channel":"C123","ts":%q}`, ts)
+	}))
+}
+
+func TestSlack_TrackDeployment(t *testing.T) {
+	var calls []slackCall
+	api := newStubSlackApi(&calls)
+	defer api.Close()
+
+	slack := NewSlack("bot-token", "#staging_log", "vektorbot", ":robot_face:")
+	slack.ApiUrl = api.URL
+
+	ctx := context.Background()
+	tracker := slack.TrackDeployment(Deployment{Repo: "vektorprogrammet/vektorprogrammet", Branch: "feature"})
+	tracker.Progress(ctx, "Creating server folder", 0)
+	tracker.Progress(ctx, "Cloning repository", 10)
+	tracker.Finish(ctx, "Staging server deployed", nil, []Action{{Id: "open", Text: "Open", Url: "https://feature.staging.vektorprogrammet.no"}})
+	tracker.Log(ctx, "```\ngit clone\n```")
+
+	expectedMethods := []string{"chat.postMessage", "chat.update", "chat.update", "chat.postMessage"}
+	if len(calls) != len(expectedMethods) {
+		t.Fatalf("Expected %d calls, got %d: %+v", len(expectedMethods), len(calls), calls)
+	}
+	for i, method := range expectedMethods {
+		if calls[i].method != method {
+			t.Errorf("Expected call %d to be %s, got %s", i, method, calls[i].method)
+		}
+	}
+
+	for _, call := range calls[1:3] {
+		if call.message.Channel != "C123" || call.message.Ts != "1000.1" {
+			t.Errorf("Expected update of message 1000.1 in C123, got %+v", call.message)
+		}
+	}
+	if calls[3].message.ThreadTs != "1000.1" {
+		t.Errorf("Expected log to be posted in thread 1000.1, got %q", calls[3].message.ThreadTs)
+	}
+
+	final := calls[2].message.Blocks
+	if len(final) != 3 || final[2].Type != "actions" {
+		t.Fatalf("Expected final message to have buttons, got %+v", final)
+	}
+	if !strings.Contains(final[0].Text.Text, "100%") {
+		t.Errorf("Expected final message to be complete, got %q", final[0].Text.Text)
+	}
+	if !strings.Contains(final[1].Text.Text, ":white_check_mark: Cloning repository") {
+		t.Errorf("Expected all steps to be completed, got %q", final[1].Text.Text)
+	}
+}
+
+func TestSlack_TrackFailedDeployment(t *testing.T) {
+	var calls []slackCall
+	api := newStubSlackApi(&calls)
+	defer api.Close()
+
+	slack := NewSlack("bot-token", "#staging_log", "vektorbot", ":robot_face:")
+	slack.ApiUrl = api.URL
+
+	ctx := context.Background()
+	tracker := slack.TrackDeployment(Deployment{Repo: "vektorprogrammet/vektorprogrammet", Branch: "feature"})
+	tracker.Progress(ctx, "Installing composer and NPM dependencies", 30)
+	tracker.Progress(ctx, "Installing composer and NPM dependencies", 30)
+	tracker.Finish(ctx, "Could not create staging server", errors.New("exit status 1"), nil)
+
+	if len(calls) != 3 {
+		t.Fatalf("Expected 3 calls, got %d", len(calls))
+	}
+	steps := calls[2].message.Blocks[1].Text.Text
+	if steps != ":x: Installing composer and NPM dependencies" {
+		t.Errorf("Expected a single failed step, got %q", steps)
+	}
+}
+
+func TestSlack_SendReportsApiErrors(t *testing.T) {
+	var calls []slackCall
+	api := newStubSlackApi(&calls)
+	defer api.Close()
+
+	slack := NewSlack("wrong-token", "#staging_log", "vektorbot", ":robot_face:")
+	slack.ApiUrl = api.URL
+
+	err := slack.Send(context.Background(), "Hello")
+	if err == nil || !strings.Contains(err.Error(), "not_authed") {
+		t.Errorf("Expected not_authed error, got %v", err)
+	}
+	if _, ok := err.(*PermanentError); !ok {
+		t.Errorf("Expected not_authed not to be retried, got %T", err)
+	}
+}
+
+func TestSlack_SendReportsRateLimits(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer api.Close()
+
+	slack := NewSlack("bot-token", "#staging_log", "vektorbot", ":robot_face:")
+	slack.ApiUrl = api.URL
+
+	err := slack.Send(context.Background(), "Hello")
+	retryAfter, ok := err.(*RetryAfterError)
+	if !ok || retryAfter.After != 30*time.Second {
+		t.Errorf("Expected to retry after 30s, got %v", err)
+	}
+}
+
+func TestSlack_DecodeErrorsAreNotRetried(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html>")
+	}))
+	defer api.Close()
+
+	slack := NewSlack("bot-token", "#staging_log", "vektorbot", ":robot_face:")
+	slack.ApiUrl = api.URL
+
+	if _, ok := slack.Send(context.Background(), "Hello").(*PermanentError); !ok {
+		t.Error("Expected a permanent error when the response can not be decoded")
+	}
+}
@@ -0,0 +1,56 @@
+package messenger
+
+import (
+	"context"
+)
+
+type Teams struct {
+	WebhookUrl string `json:"webhook_url"`
+}
+
+type teamsMessageCard struct {
+	Type            string        `json:"@type"`
+	Context         string        `json:"@context"`
+	Summary         string        `json:"summary"`
+	Text            string        `json:"text"`
+	PotentialAction []teamsAction `json:"potentialAction,omitempty"`
+}
+
+type teamsAction struct {
+	Type    string        `json:"@type"`
+	Name    string        `json:"name"`
+	Targets []teamsTarget `json:"targets"`
+}
+
+type teamsTarget struct {
+	Os  string `json:"os"`
+	Uri string `json:"uri"`
+}
+
+func NewTeams(webhookUrl string) Teams {
+	return Teams{WebhookUrl: webhookUrl}
+}
+
+func (t Teams) Send(ctx context.Context, message string) error {
+	return t.SendWithActions(ctx, message, nil)
+}
+
+func (t Teams) SendWithActions(ctx context.Context, message string, actions []Action) error {
+	card := teamsMessageCard{
+		Type:    "MessageCard",
+		Context: "https://schema.org/extensions",
+		Summary: truncate(message, 100),
+		Text:    message,
+	}
+	for _, action := range actions {
+		if action.Url != "" {
+			card.PotentialAction = append(card.PotentialAction, teamsAction{
+				Type:    "OpenUri",
+				Name:    action.Text,
+				Targets: []teamsTarget{{Os: "default", Uri: action.Url}},
+			})
+		}
+	}
+
+	return postJson(ctx, t.WebhookUrl, card, nil)
+}
@@ -0,0 +1,190 @@
+package messenger
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Event is the structured state of a deployment that templates turn into
+// channel specific messages.
+type Event struct {
+	Type       string
+	Deployment Deployment
+	Message    string
+	Progress   int
+	Steps      []Step
+	Error      string
+	Logs       []string
+	Actions    []Action
+}
+
+type Step struct {
+	Message  string
+	Progress int
+	Done     bool
+	Failed   bool
+}
+
+func (e Event) Finished() bool {
+	return e.Type == EventSuccess || e.Type == EventFailure
+}
+
+func (e *Event) progress(message string, progress int) {
+	e.Type = EventProgress
+	e.Message = message
+	e.Progress = progress
+	if len(e.Steps) > 0 && e.Steps[len(e.Steps)-1].Message == message {
+		e.Steps[len(e.Steps)-1].Progress = progress
+		return
+	}
+	for i := range e.Steps {
+		e.Steps[i].Done = true
+	}
+	e.Steps = append(e.Steps, Step{Message: message, Progress: progress})
+}
+
+func (e *Event) log(message string) {
+	e.Logs = append(e.Logs, message)
+}
+
+func (e *Event) finish(message string, err error, actions []Action) {
+	e.Message = message
+	e.Actions = actions
+	if err != nil {
+		e.Type = EventFailure
+		e.Error = err.Error()
+		if len(e.Steps) > 0 {
+			e.Steps[len(e.Steps)-1].Failed = true
+		}
+		return
+	}
+
+	e.Type = EventSuccess
+	e.Progress = 100
+	for i := range e.Steps {
+		e.Steps[i].Done = true
+	}
+}
+
+const (
+	TemplateText   = "text"
+	TemplateSlack  = "slack"
+	TemplateGithub = "github"
+	TemplateEmail  = "email"
+)
+
+var defaultTemplates = map[string]string{
+	TemplateText: `{{.Deployment.Branch}}: {{.Message}}{{if not .Finished}} ({{.Progress}}%){{end}}`,
+
+	TemplateSlack: `*{{.Deployment.Branch}}*
+` + "`{{progressBar .Progress}}`" + ` {{.Progress}}%
+{{- if .Finished}}
+{{.Message}}{{end}}
+
+{{range .Steps}}
+{{- if .Failed}}:x:{{else if .Done}}:white_check_mark:{{else}}:hourglass_flowing_sand:{{end}} {{.Message}}
+{{end}}`,
+
+	TemplateGithub: `{{if eq .Type "failure"}}:x: **Could not deploy ` + "`{{.Deployment.Branch}}`" + ` to the staging server**
+{{else if .Finished}}:white_check_mark: **{{.Message}}**
+{{else}}:hourglass_flowing_sand: **Deploying this pull request to the staging server... {{.Progress}}%**
+{{end}}
+{{- if .Steps}}
+| | Step | Progress |
+|---|---|---|
+{{range .Steps}}| {{if .Failed}}:x:{{else if .Done}}:white_check_mark:{{else}}:hourglass_flowing_sand:{{end}} | {{.Message}} | {{.Progress}}% |
+{{end}}{{end}}
+{{- if .Error}}
+{{.Message}}
+{{end}}
+{{- range .Actions}}{{if and .Url (ne .Id "logs")}}
+[{{.Text}}]({{.Url}}){{end}}{{end}}
+{{- if .Deployment.LogUrl}}
+[Deploy log]({{.Deployment.LogUrl}})
+{{end}}`,
+
+	TemplateEmail: `{{.Message}}
+{{if .Steps}}
+Steps:
+{{range .Steps}}{{printf "%3d%%" .Progress}} {{.Message}}{{if .Failed}} (failed){{end}}
+{{end}}{{end}}
+{{- range .Actions}}{{if .Url}}
+{{.Text}}: {{.Url}}{{end}}{{end}}
+{{- range .Logs}}
+
+{{.}}{{end}}
+`,
+}
+
+var templateFuncs = template.FuncMap{
+	"progressBar": progressBar,
+	"truncate":    truncate,
+}
+
+type Templates map[string]*template.Template
+
+var templates = DefaultTemplates()
+
+func DefaultTemplates() Templates {
+	t := Templates{}
+	for name, text := range defaultTemplates {
+		t[name] = template.Must(template.New(name).Funcs(templateFuncs).Parse(text))
+	}
+	return t
+}
+
+// LoadTemplates reads <name>.tmpl files from folder on top of the default
+// templates. A missing folder means no overrides.
+func LoadTemplates(folder string) (Templates, error) {
+	t := DefaultTemplates()
+	for name := range defaultTemplates {
+		text, err := ioutil.ReadFile(filepath.Join(folder, name+".tmpl"))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		override, err := template.New(name).Funcs(templateFuncs).Parse(string(text))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %s template: %s", name, err)
+		}
+		t[name] = override
+	}
+	return t, nil
+}
+
+// UseTemplates replaces the templates used by all messengers.
+func UseTemplates(t Templates) {
+	templates = t
+}
+
+// Render formats an event with the named template. Templates that fail are
+// reported to logger, which defaults to slog.Default, and the event is
+// formatted as plain text instead.
+func Render(logger *slog.Logger, name string, e Event) string {
+	t, ok := templates[name]
+	if !ok {
+		t = DefaultTemplates()[name]
+	}
+	if t == nil {
+		return e.Deployment.Branch + ": " + e.Message
+	}
+
+	var out bytes.Buffer
+	if err := t.Execute(&out, e); err != nil {
+		if logger == nil {
+			logger = slog.Default()
+		}
+		logger.Error("Could not render template", "template", name, "error", err)
+		return e.Deployment.Branch + ": " + e.Message
+	}
+	return strings.TrimSpace(out.String())
+}
@@ -0,0 +1,103 @@
+package messenger
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func failedEvent() Event {
+	e := Event{Deployment: Deployment{Repo: "vektorprogrammet/vektorprogrammet", Branch: "feature", LogUrl: "https://staging.example.com/api/servers/feature/logs"}}
+	e.progress("Cloning repository", 10)
+	e.progress("Installing composer and NPM dependencies", 30)
+	e.log("```\nnpm ERR! missing script\n```")
+	e.finish("Could not create staging server: exit status 1", errors.New("exit status 1"), nil)
+	return e
+}
+
+func TestRender_Github(t *testing.T) {
+	comment := Render(nil, TemplateGithub, failedEvent())
+
+	for _, expected := range []string{
+		":x: **Could not deploy `feature` to the staging server**",
+		"| :white_check_mark: | Cloning repository | 10% |",
+		"| :x: | Installing composer and NPM dependencies | 30% |",
+		"[Deploy log](https://staging.example.com/api/servers/feature/logs)",
+	} {
+		if !strings.Contains(comment, expected) {
+			t.Errorf("Expected comment to contain %q, got:\n%s", expected, comment)
+		}
+	}
+	if strings.Contains(comment, "npm ERR!") {
+		t.Errorf("Expected the deploy log to be left out of the comment, got:\n%s", comment)
+	}
+}
+
+func TestRender_Text(t *testing.T) {
+	e := Event{Deployment: Deployment{Branch: "feature"}}
+	e.progress("Cloning repository", 10)
+	if text := Render(nil, TemplateText, e); text != "feature: Cloning repository (10%)" {
+		t.Errorf("Unexpected progress text %q", text)
+	}
+
+	e.finish("Staging server deployed at https://feature.staging.vektorprogrammet.no", nil, nil)
+	if text := Render(nil, TemplateText, e); text != "feature: Staging server deployed at https://feature.staging.vektorprogrammet.no" {
+		t.Errorf("Unexpected finish text %q", text)
+	}
+}
+
+func TestRender_LogsFailingTemplates(t *testing.T) {
+	defer UseTemplates(DefaultTemplates())
+	UseTemplates(Templates{TemplateText: template.Must(template.New(TemplateText).Parse("{{.Missing}}"))})
+
+	var log bytes.Buffer
+	text := Render(slog.New(slog.NewTextHandler(&log, nil)), TemplateText, failedEvent())
+	if text != "feature: Could not create staging server: exit status 1" {
+		t.Errorf("Expected the plain text of the event, got %q", text)
+	}
+	if !strings.Contains(log.String(), "Could not render template") {
+		t.Errorf("Expected the failure to be logged to the given logger, got %q", log.String())
+	}
+}
+
+func TestLoadTemplates_Overrides(t *testing.T) {
+	folder, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	ioutil.WriteFile(filepath.Join(folder, "text.tmpl"), []byte("[{{.Deployment.Branch}}] {{.Message}}"), 0644)
+
+	loaded, err := LoadTemplates(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	UseTemplates(loaded)
+	defer UseTemplates(DefaultTemplates())
+
+	if text := Render(nil, TemplateText, failedEvent()); text != "[feature] Could not create staging server: exit status 1" {
+		t.Errorf("Expected overridden text template, got %q", text)
+	}
+	if comment := Render(nil, TemplateGithub, failedEvent()); !strings.Contains(comment, "| Step |") {
+		t.Errorf("Expected default github template, got %q", comment)
+	}
+}
+
+func TestLoadTemplates_InvalidTemplate(t *testing.T) {
+	folder, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	ioutil.WriteFile(filepath.Join(folder, "slack.tmpl"), []byte("{{.Message"), 0644)
+
+	if _, err := LoadTemplates(folder); err == nil {
+		t.Error("Expected error for invalid template")
+	}
+}
@@ -0,0 +1,106 @@
+package messenger
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// Webhook posts notifications as JSON to any URL. Payloads are signed with
+// an HMAC-SHA256 of "<timestamp>.<body>" in the X-Signature-256 header, and
+// the Unix timestamp is sent in X-Signature-Timestamp, so receivers can reject
+// old deliveries that are replayed.
+type Webhook struct {
+	Url    string `json:"url"`
+	Secret string `json:"secret"`
+}
+
+type webhookPayload struct {
+	Event    string   `json:"event"`
+	Repo     string   `json:"repo,omitempty"`
+	Branch   string   `json:"branch,omitempty"`
+	Message  string   `json:"message"`
+	Progress int      `json:"progress,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	Actions  []Action `json:"actions,omitempty"`
+	Time     string   `json:"time"`
+}
+
+func NewWebhook(url, secret string) Webhook {
+	return Webhook{
+		Url:    url,
+		Secret: secret,
+	}
+}
+
+func (w Webhook) Send(ctx context.Context, message string) error {
+	return w.post(ctx, webhookPayload{Event: EventMessage, Message: message})
+}
+
+func (w Webhook) SendWithActions(ctx context.Context, message string, actions []Action) error {
+	return w.post(ctx, webhookPayload{Event: EventMessage, Message: message, Actions: actions})
+}
+
+func (w Webhook) TrackDeployment(d Deployment) DeploymentTracker {
+	return &webhookDeployment{webhook: w, deployment: d}
+}
+
+func (w Webhook) post(ctx context.Context, payload webhookPayload) error {
+	now := time.Now()
+	payload.Time = now.UTC().Format(time.RFC3339)
+
+	return postJson(ctx, w.Url, payload, func(body []byte) map[string]string {
+		if w.Secret == "" {
+			return nil
+		}
+		timestamp := strconv.FormatInt(now.Unix(), 10)
+		return map[string]string{
+			"X-Signature-256":       "sha256=" + w.sign(timestamp, body),
+			"X-Signature-Timestamp": timestamp,
+		}
+	})
+}
+
+func (w Webhook) sign(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type webhookDeployment struct {
+	webhook    Webhook
+	deployment Deployment
+}
+
+func (d *webhookDeployment) Progress(ctx context.Context, message string, progress int) error {
+	return d.webhook.post(ctx, webhookPayload{
+		Event:    EventProgress,
+		Repo:     d.deployment.Repo,
+		Branch:   d.deployment.Branch,
+		Message:  message,
+		Progress: progress,
+	})
+}
+
+func (d *webhookDeployment) Log(ctx context.Context, message string) error {
+	return nil
+}
+
+func (d *webhookDeployment) Finish(ctx context.Context, message string, err error, actions []Action) error {
+	payload := webhookPayload{
+		Event:   EventSuccess,
+		Repo:    d.deployment.Repo,
+		Branch:  d.deployment.Branch,
+		Message: message,
+		Actions: actions,
+	}
+	if err != nil {
+		payload.Event = EventFailure
+		payload.Error = err.Error()
+	}
+	return d.webhook.post(ctx, payload)
+}
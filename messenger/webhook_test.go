@@ -0,0 +1,86 @@
+package messenger
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWebhook_SignsStructuredEvents(t *testing.T) {
+	var payloads []webhookPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get("X-Signature-Timestamp"), 10, 64)
+		if time.Since(time.Unix(timestamp, 0)) > time.Minute {
+			t.Errorf("Expected a current timestamp, got %q", r.Header.Get("X-Signature-Timestamp"))
+		}
+		mac := hmac.New(sha256.New, []byte("webhook-secret"))
+		mac.Write([]byte(r.Header.Get("X-Signature-Timestamp") + "."))
+		mac.Write(body)
+		if r.Header.Get("X-Signature-256") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			t.Errorf("Invalid signature %q", r.Header.Get("X-Signature-256"))
+		}
+
+		var payload webhookPayload
+		json.Unmarshal(body, &payload)
+		payloads = append(payloads, payload)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	tracker := NewWebhook(server.URL, "webhook-secret").TrackDeployment(Deployment{Repo: "vektorprogrammet/vektorprogrammet", Branch: "feature"})
+	tracker.Progress(ctx, "Cloning repository", 10)
+	tracker.Finish(ctx, "Could not create staging server", errors.New("exit status 1"), nil)
+
+	if len(payloads) != 2 {
+		t.Fatalf("Expected 2 payloads, got %d", len(payloads))
+	}
+	if payloads[0].Event != EventProgress || payloads[0].Progress != 10 || payloads[0].Branch != "feature" {
+		t.Errorf("Unexpected progress payload %+v", payloads[0])
+	}
+	if payloads[1].Event != EventFailure || payloads[1].Error != "exit status 1" || payloads[1].Repo != "vektorprogrammet/vektorprogrammet" {
+		t.Errorf("Unexpected failure payload %+v", payloads[1])
+	}
+}
+
+func TestDiscord_Send(t *testing.T) {
+	var message discordMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&message)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	err := NewDiscord(server.URL, "vektorbot").SendWithActions(context.Background(), "feature: Staging server deployed", []Action{
+		{Id: "open", Text: "Open", Url: "https://feature.staging.vektorprogrammet.no"},
+		{Id: "redeploy", Text: "Redeploy", Value: "feature"},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "feature: Staging server deployed\n[Open](<https://feature.staging.vektorprogrammet.no>)"
+	if message.Content != expected || message.Username != "vektorbot" {
+		t.Errorf("Expected %q, got %+v", expected, message)
+	}
+}
+
+func TestTeams_SendReportsErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	if err := NewTeams(server.URL).Send(context.Background(), "message"); err == nil {
+		t.Error("Expected error for unsuccessful response")
+	}
+}
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are registered with the default Prometheus registry by the packages
+// that report them, and served by promhttp.
+
+// DefaultBuckets are the upper bounds in seconds of histograms of deploy
+// steps, which take from seconds to several minutes.
+var DefaultBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200}
+
+var (
+	diskSizeDesc = prometheus.NewDesc("staging_disk_size_bytes", "Size of the disk of the staging servers.", nil, nil)
+	diskUsedDesc = prometheus.NewDesc("staging_disk_used_bytes", "Used space on the disk of the staging servers.", nil, nil)
+)
+
+// DiskCollector reports the size and usage of the disk of the staging
+// servers. Both are read together once per scrape.
+type DiskCollector struct {
+	// Space returns the size and usage of the disk in kilobytes.
+	Space func() (size int, used int, err error)
+	// Logger defaults to slog.Default.
+	Logger *slog.Logger
+}
+
+func (c *DiskCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- diskSizeDesc
+	ch <- diskUsedDesc
+}
+
+func (c *DiskCollector) Collect(ch chan<- prometheus.Metric) {
+	size, used, err := c.Space()
+	if err != nil {
+		c.logger().Warn("Could not get disk space", "error", err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(diskSizeDesc, prometheus.GaugeValue, float64(size)*1024)
+	ch <- prometheus.MustNewConstMetric(diskUsedDesc, prometheus.GaugeValue, float64(used)*1024)
+}
+
+func (c *DiskCollector) logger() *slog.Logger {
+	if c.Logger == nil {
+		return slog.Default()
+	}
+	return c.Logger
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDiskCollector(t *testing.T) {
+	reads := 0
+	c := &DiskCollector{Space: func() (int, int, error) {
+		reads++
+		return 100, 40, nil
+	}}
+
+	expected := `
+# HELP staging_disk_size_bytes Size of the disk of the staging servers.
+# TYPE staging_disk_size_bytes gauge
+staging_disk_size_bytes 102400
+# HELP staging_disk_used_bytes Used space on the disk of the staging servers.
+# TYPE staging_disk_used_bytes gauge
+staging_disk_used_bytes 40960
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+	if reads != 1 {
+		t.Errorf("Expected the disk to be read once per scrape, read %d times", reads)
+	}
+}
+
+func TestDiskCollectorSkipsUnknownSpace(t *testing.T) {
+	c := &DiskCollector{Space: func() (int, int, error) { return 0, 0, errors.New("no disk") }}
+
+	if count := testutil.CollectAndCount(c); count != 0 {
+		t.Errorf("Expected no metrics without disk space, got %d", count)
+	}
+}
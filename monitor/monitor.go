@@ -0,0 +1,134 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/vektorprogrammet/build-system/health"
+	"github.com/vektorprogrammet/build-system/messenger"
+	"github.com/vektorprogrammet/build-system/staging"
+)
+
+const DefaultInterval = 5 * time.Minute
+
+// DefaultHistory is how long probe results are kept.
+const DefaultHistory = 24 * time.Hour
+
+// Monitor probes every staging server in the background, keeps a history of
+// the results and sends a message when a server goes down or comes back up.
+type Monitor struct {
+	Check     health.Check
+	Servers   func() []staging.Server
+	Messenger messenger.Messenger
+	Interval  time.Duration
+	// History is how long results are kept. Together with Interval it sets
+	// the number of results in the history.
+	History time.Duration
+	// Busy reports whether a job is running on the server with a name. Busy
+	// servers are skipped, since the job checks their health itself.
+	Busy func(serverName string) bool
+	// Url is the address that is probed. It defaults to the HTTPS address of
+	// the server.
+	Url func(server *staging.Server) string
+	// Logger defaults to slog.Default.
+	Logger *slog.Logger
+}
+
+func NewMonitor(check health.Check, servers func() []staging.Server, m messenger.Messenger) *Monitor {
+	return &Monitor{
+		Check:     check,
+		Servers:   servers,
+		Messenger: m,
+		Interval:  DefaultInterval,
+		History:   DefaultHistory,
+	}
+}
+
+func (m *Monitor) Start() {
+	go func() {
+		for {
+			m.ProbeAll()
+			time.Sleep(m.Interval)
+		}
+	}()
+}
+
+func (m *Monitor) ProbeAll() {
+	for _, server := range m.Servers() {
+		m.Probe(&server)
+	}
+}
+
+// Probe checks a single server and records the result. Servers without an
+// earlier report, like servers that are still being deployed, do not send
+// alerts. Nothing is recorded for servers that are busy with a job.
+func (m *Monitor) Probe(server *staging.Server) health.Report {
+	logger := m.logger().With("branch", server.Branch)
+	if m.busy(server) {
+		logger.Debug("Skipping probe, a job is running on the server")
+		return health.Report{}
+	}
+	previous, err := health.LoadReport(server.HealthFile())
+	if err != nil {
+		logger.Warn("Could not read health", "error", err)
+	}
+
+	report := m.Check.Run(m.url(server))
+	logger.Debug("Probed server", "healthy", report.Healthy, "latency_ms", report.LatencyMs())
+	if m.busy(server) {
+		logger.Debug("Discarding probe, a job started on the server")
+		return report
+	}
+	if err := health.SaveReport(server.HealthFile(), report); err != nil {
+		logger.Warn("Could not save health", "error", err)
+	}
+	if err := health.AppendHistory(server.HealthHistoryFile(), report, m.historySize()); err != nil {
+		logger.Warn("Could not save health history", "error", err)
+	}
+
+	if previous != nil && previous.Healthy != report.Healthy {
+		m.alert(logger, server, report)
+	}
+	return report
+}
+
+func (m *Monitor) alert(logger *slog.Logger, server *staging.Server, report health.Report) {
+	message := fmt.Sprintf("%s is up again: %s", server.ServerName(), report)
+	if !report.Healthy {
+		message = fmt.Sprintf("%s is down: %s", server.ServerName(), report)
+	}
+	logger.Warn("Server health changed", "healthy", report.Healthy, "report", report.String())
+	if m.Messenger == nil {
+		return
+	}
+	if err := m.Messenger.Send(context.Background(), message); err != nil {
+		logger.Warn("Could not send health alert", "error", err)
+	}
+}
+
+func (m *Monitor) busy(server *staging.Server) bool {
+	return m.Busy != nil && m.Busy(server.ServerName())
+}
+
+func (m *Monitor) historySize() int {
+	if m.Interval <= 0 || m.History < m.Interval {
+		return 1
+	}
+	return int(m.History / m.Interval)
+}
+
+func (m *Monitor) logger() *slog.Logger {
+	if m.Logger == nil {
+		return slog.Default()
+	}
+	return m.Logger
+}
+
+func (m *Monitor) url(server *staging.Server) string {
+	if m.Url != nil {
+		return m.Url(server)
+	}
+	return "https://" + server.ServerName()
+}
@@ -0,0 +1,115 @@
+package monitor
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/vektorprogrammet/build-system/health"
+	"github.com/vektorprogrammet/build-system/staging"
+)
+
+type testMessenger struct {
+	messages []string
+}
+
+func (m *testMessenger) Send(ctx context.Context, message string) error {
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+func TestMonitorAlertsOnStateChanges(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "monitor")
+	defer os.RemoveAll(dir)
+
+	up := true
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer site.Close()
+
+	server := staging.NewServer("feature", nil)
+	server.RootFolder = dir
+	os.Mkdir(dir+"/feature", 0755)
+
+	messages := &testMessenger{}
+	m := NewMonitor(health.Check{}, func() []staging.Server { return []staging.Server{server} }, messages)
+	m.Interval = time.Minute
+	m.History = 3 * time.Minute
+	m.Url = func(*staging.Server) string { return site.URL }
+
+	for _, state := range []bool{true, true, false, false, true} {
+		up = state
+		m.ProbeAll()
+	}
+
+	if len(messages.messages) != 2 {
+		t.Fatalf("Expected a message when going down and up again, got %v", messages.messages)
+	}
+	history, err := health.LoadHistory(server.HealthHistoryFile())
+	if err != nil || len(history) != 3 {
+		t.Fatalf("Expected 3 reports in the history, got %d, %v", len(history), err)
+	}
+	if uptime := health.Uptime(history); uptime < 33 || uptime > 34 {
+		t.Errorf("Expected 33%% uptime, got %f", uptime)
+	}
+}
+
+func TestMonitorSkipsBusyServers(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "monitor")
+	defer os.RemoveAll(dir)
+
+	requests := 0
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer site.Close()
+
+	server := staging.NewServer("feature", nil)
+	server.RootFolder = dir
+	os.Mkdir(dir+"/feature", 0755)
+
+	m := NewMonitor(health.Check{}, func() []staging.Server { return []staging.Server{server} }, nil)
+	m.Url = func(*staging.Server) string { return site.URL }
+	m.Busy = func(name string) bool { return name == server.ServerName() }
+	m.ProbeAll()
+
+	if requests != 0 {
+		t.Errorf("Expected a busy server not to be probed, got %d requests", requests)
+	}
+	if report, _ := health.LoadReport(server.HealthFile()); report != nil {
+		t.Errorf("Expected no report for a busy server, got %+v", report)
+	}
+}
+
+func TestMonitorSkipsBusyServersOfSlashedBranches(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "monitor")
+	defer os.RemoveAll(dir)
+
+	requests := 0
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer site.Close()
+
+	// The job knows the branch, while servers are listed by their folder.
+	deploying := staging.NewServer("feature/Busy_Server", nil)
+	server := staging.NewServer("featurebusy-server", nil)
+	server.RootFolder = dir
+	os.Mkdir(dir+"/featurebusy-server", 0755)
+
+	m := NewMonitor(health.Check{}, func() []staging.Server { return []staging.Server{server} }, nil)
+	m.Url = func(*staging.Server) string { return site.URL }
+	m.Busy = func(name string) bool { return name == deploying.ServerName() }
+	m.ProbeAll()
+
+	if requests != 0 {
+		t.Errorf("Expected the server of a busy slashed branch not to be probed, got %d requests", requests)
+	}
+}
@@ -24,7 +24,10 @@ server {
 		fastcgi_pass unix:/var/run/php/php7.1-fpm.sock;
 		fastcgi_split_path_info ^(.+\.php)(/.*)$;
 		include fastcgi_params;
-		fastcgi_param SCRIPT_FILENAME $document_root$fastcgi_script_name;
+		fastcgi_param SCRIPT_FILENAME $realpath_root$fastcgi_script_name;
+		# Resolve the current symlink, so PHP sees the release that was live
+		# when the request started
+		fastcgi_param DOCUMENT_ROOT $realpath_root;
 		# Prevents URIs that include the front controller. This will 404:
 		# http://domain.tld/app.php/some-path
 		# Remove the internal directive to allow URIs like this
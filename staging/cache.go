@@ -0,0 +1,164 @@
+package staging
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const DefaultCacheFolder = DefaultInstallationFolder + "/cache"
+
+const DefaultCacheSize = 10 << 30
+
+// DependencyCache keeps copies of installed dependency folders, like vendor
+// and node_modules, keyed by the hash of the manifest and lock file they were
+// installed from. Servers with the same files start from a copy instead of
+// downloading everything again.
+type DependencyCache struct {
+	Folder  string
+	MaxSize int64
+	Logger  *slog.Logger
+}
+
+// Key identifies the dependencies installed from a manifest and its lock
+// file. It is empty if one of the files does not exist.
+func (c DependencyCache) Key(name string, files ...string) string {
+	hash := sha256.New()
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return ""
+		}
+		fmt.Fprintf(hash, "%d\n", len(content))
+		hash.Write(content)
+	}
+	return name + "-" + hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// Restore copies the cached folder for key to target. It returns false if
+// there is nothing cached.
+func (c DependencyCache) Restore(key, target string) (bool, error) {
+	if key == "" {
+		return false, nil
+	}
+	entry := c.entry(key)
+	if _, err := os.Stat(entry); err != nil {
+		return false, nil
+	}
+
+	if err := copyFolder(entry, target); err != nil {
+		return false, err
+	}
+	now := time.Now()
+	return true, os.Chtimes(entry, now, now)
+}
+
+// Store copies an installed folder to the cache and evicts the least recently
+// used entries if the cache gets too big.
+func (c DependencyCache) Store(key, source string) error {
+	if key == "" {
+		return nil
+	}
+	if _, err := os.Stat(c.entry(key)); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(c.Folder, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempDir(c.Folder, ".tmp-"+key)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := copyFolder(source, filepath.Join(tmp, key)); err != nil {
+		return err
+	}
+	if err := os.Rename(filepath.Join(tmp, key), c.entry(key)); err != nil {
+		// Another server may have stored the same dependencies meanwhile.
+		if _, statErr := os.Stat(c.entry(key)); statErr != nil {
+			return err
+		}
+	}
+
+	return c.Evict()
+}
+
+// Evict removes the least recently used entries until the cache is smaller
+// than MaxSize.
+func (c DependencyCache) Evict() error {
+	files, err := ioutil.ReadDir(c.Folder)
+	if err != nil {
+		return err
+	}
+
+	type entry struct {
+		path   string
+		size   int64
+		usedAt time.Time
+	}
+	var entries []entry
+	var total int64
+	for _, file := range files {
+		if !file.IsDir() || file.Name() == "packages" || file.Name()[0] == '.' {
+			continue
+		}
+		path := filepath.Join(c.Folder, file.Name())
+		size := folderSize(path)
+		entries = append(entries, entry{path, size, file.ModTime()})
+		total += size
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].usedAt.Before(entries[j].usedAt) })
+	for _, e := range entries {
+		if total <= c.MaxSize {
+			break
+		}
+		c.logger().Info("Evicting from the dependency cache", "entry", filepath.Base(e.path), "size", e.size)
+		if err := os.RemoveAll(e.path); err != nil {
+			return err
+		}
+		total -= e.size
+	}
+	return nil
+}
+
+func (c DependencyCache) logger() *slog.Logger {
+	if c.Logger == nil {
+		return slog.Default()
+	}
+	return c.Logger
+}
+
+func (c DependencyCache) entry(key string) string {
+	return filepath.Join(c.Folder, key)
+}
+
+func copyFolder(source, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	output, err := exec.Command("cp", "-a", source, target).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("could not copy %s: %s: %s", source, err, output)
+	}
+	return nil
+}
+
+func folderSize(path string) int64 {
+	var size int64
+	filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,124 @@
+package staging
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDependencyCacheKey(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "cache")
+	defer os.RemoveAll(dir)
+	cache := DependencyCache{Folder: filepath.Join(dir, "cache")}
+
+	writeFile(t, filepath.Join(dir, "a.lock"), "a")
+	writeFile(t, filepath.Join(dir, "b.lock"), "b")
+	writeFile(t, filepath.Join(dir, "c.lock"), "a")
+
+	a := cache.Key("vendor", filepath.Join(dir, "a.lock"))
+	if a == "" || a == cache.Key("vendor", filepath.Join(dir, "b.lock")) {
+		t.Errorf("Expected different lock files to have different keys, got %q", a)
+	}
+	if a != cache.Key("vendor", filepath.Join(dir, "c.lock")) {
+		t.Errorf("Expected identical lock files to have the same key")
+	}
+	if key := cache.Key("vendor", filepath.Join(dir, "missing.lock")); key != "" {
+		t.Errorf("Expected no key without a lock file, got %q", key)
+	}
+
+	writeFile(t, filepath.Join(dir, "composer.json"), `{"require": {}}`)
+	withManifest := cache.Key("vendor", filepath.Join(dir, "composer.json"), filepath.Join(dir, "a.lock"))
+	writeFile(t, filepath.Join(dir, "composer.json"), `{"require": {"symfony/symfony": "*"}}`)
+	if withManifest == cache.Key("vendor", filepath.Join(dir, "composer.json"), filepath.Join(dir, "a.lock")) {
+		t.Errorf("Expected a changed manifest to change the key")
+	}
+}
+
+func TestDependencyCacheStoreAndRestore(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "cache")
+	defer os.RemoveAll(dir)
+	cache := DependencyCache{Folder: filepath.Join(dir, "cache"), MaxSize: 1 << 20}
+
+	if hit, err := cache.Restore("vendor-1", filepath.Join(dir, "first", "vendor")); hit || err != nil {
+		t.Fatalf("Expected a miss on an empty cache, got %v, %v", hit, err)
+	}
+
+	writeFile(t, filepath.Join(dir, "first", "vendor", "autoload.php"), "<?php")
+	if err := cache.Store("vendor-1", filepath.Join(dir, "first", "vendor")); err != nil {
+		t.Fatal(err)
+	}
+
+	hit, err := cache.Restore("vendor-1", filepath.Join(dir, "second", "vendor"))
+	if !hit || err != nil {
+		t.Fatalf("Expected a hit, got %v, %v", hit, err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dir, "second", "vendor", "autoload.php"))
+	if err != nil || string(content) != "<?php" {
+		t.Errorf("Expected restored autoload.php, got %q, %v", content, err)
+	}
+}
+
+func TestDependencyCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "cache")
+	defer os.RemoveAll(dir)
+	cache := DependencyCache{Folder: dir, MaxSize: 15}
+
+	for i, key := range []string{"old", "used", "new"} {
+		writeFile(t, filepath.Join(dir, key, "file"), "0123456789")
+		used := time.Now().Add(time.Duration(i-3) * time.Hour)
+		os.Chtimes(filepath.Join(dir, key), used, used)
+	}
+	writeFile(t, filepath.Join(dir, "packages", "composer", "file"), "0123456789")
+	if hit, _ := cache.Restore("used", filepath.Join(dir, "..", filepath.Base(dir)+"-restored")); !hit {
+		t.Fatal("Expected a hit")
+	}
+	defer os.RemoveAll(filepath.Join(dir, "..", filepath.Base(dir)+"-restored"))
+
+	if err := cache.Evict(); err != nil {
+		t.Fatal(err)
+	}
+	for key, kept := range map[string]bool{"old": false, "new": false, "used": true, "packages": true} {
+		if _, err := os.Stat(filepath.Join(dir, key)); (err == nil) != kept {
+			t.Errorf("Expected %s to be kept: %v", key, kept)
+		}
+	}
+}
+
+func TestInstallCachedDoesNotStoreUntrustedDependencies(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "cache")
+	defer os.RemoveAll(dir)
+
+	s := NewServer("pr-42", nil)
+	s.CacheFolder = filepath.Join(dir, "cache")
+	s.release = filepath.Join(dir, "release")
+	writeFile(t, filepath.Join(s.release, "composer.json"), "{}")
+	writeFile(t, filepath.Join(s.release, "composer.lock"), "{}")
+	writeFile(t, filepath.Join(s.release, "vendor", "autoload.php"), "<?php")
+
+	s.Ref = "refs/pull/42/head"
+	if err := s.installCached("vendor", "true", "composer.json", "composer.lock"); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := ioutil.ReadDir(s.CacheFolder); len(entries) != 0 {
+		t.Errorf("Expected dependencies of a fork not to be cached, got %d entries", len(entries))
+	}
+
+	s.Ref = ""
+	if err := s.installCached("vendor", "true", "composer.json", "composer.lock"); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := ioutil.ReadDir(s.CacheFolder); len(entries) != 1 {
+		t.Errorf("Expected the dependencies to be cached, got %d entries", len(entries))
+	}
+}
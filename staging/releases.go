@@ -0,0 +1,304 @@
+package staging
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/vektorprogrammet/build-system/git"
+)
+
+// Every deploy and update is built in releases/<sha> next to the live
+// release. The current symlink, which nginx serves, is swapped to the new
+// release once it is installed and migrated, so reviewers never see a half
+// built site and a failed build leaves the live release alone.
+
+const DefaultKeepReleases = 3
+
+const newRelease = ".new"
+
+func (s *Server) releasesFolder() string {
+	return s.folder() + "/releases"
+}
+
+// releasesFile lists the releases in the order they were activated, the most
+// recent first.
+func (s *Server) releasesFile() string {
+	return s.folder() + "/.staging-releases"
+}
+
+func (s *Server) releaseFolder(sha string) string {
+	return s.releasesFolder() + "/" + sha
+}
+
+func (s *Server) currentLink() string {
+	return s.folder() + "/current"
+}
+
+// currentFolder is the live release. Servers deployed before releases were
+// introduced have their working copy directly in the server folder.
+func (s *Server) currentFolder() string {
+	if _, err := os.Lstat(s.currentLink()); err != nil {
+		return s.folder()
+	}
+	return s.currentLink()
+}
+
+// currentRelease is the SHA of the live release, or an empty string for
+// servers without releases.
+func (s *Server) currentRelease() string {
+	target, err := os.Readlink(s.currentLink())
+	if err != nil {
+		return ""
+	}
+	return path.Base(target)
+}
+
+// workDir is where commands run: the release being built, if any, and
+// otherwise the live release.
+func (s *Server) workDir() string {
+	if s.release != "" {
+		return s.release
+	}
+	return s.currentFolder()
+}
+
+// buildRelease clones the repository into a new release folder and checks out
+// commit, or the head of the branch if commit is empty.
+func (s *Server) buildRelease(commit string) error {
+	tmp := s.releaseFolder(newRelease)
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return err
+	}
+
+	s.release = tmp
+	if err := s.clone(); err != nil {
+		return err
+	}
+	if err := s.checkout(commit); err != nil {
+		return err
+	}
+
+	sha, err := git.Repo{Dir: tmp}.Head()
+	if err != nil {
+		return err
+	}
+	if sha == s.currentRelease() {
+		return fmt.Errorf("release %s is already live", shortSha(sha))
+	}
+	release := s.releaseFolder(sha)
+	if err := os.RemoveAll(release); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, release); err != nil {
+		return err
+	}
+	s.release = release
+	return nil
+}
+
+// activate swaps the current symlink to the release that was built and
+// removes the oldest releases.
+func (s *Server) activate() error {
+	sha := path.Base(s.release)
+	if err := s.link(sha); err != nil {
+		return err
+	}
+	s.release = ""
+
+	releases, err := s.Releases()
+	if err != nil {
+		return err
+	}
+	order := []string{sha}
+	for _, release := range releases {
+		if release != sha {
+			order = append(order, release)
+		}
+	}
+	if err := s.writeReleases(order); err != nil {
+		return err
+	}
+	return s.pruneReleases()
+}
+
+// link atomically points the current symlink to a release.
+func (s *Server) link(sha string) error {
+	tmp := s.currentLink() + newRelease
+	os.Remove(tmp)
+	if err := os.Symlink("releases/"+sha, tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.currentLink())
+}
+
+// Rollback switches back to an earlier release, by default the one that was
+// live before the current release. Rolling back does not change the order of
+// releases, so rolling back again goes further back.
+//
+// With restoreDatabase the database is restored from the snapshot taken
+// before the next newer release migrated it.
+func (s *Server) Rollback(release string, restoreDatabase bool) (string, error) {
+	releases, err := s.Releases()
+	if err != nil {
+		return "", err
+	}
+	current := s.currentRelease()
+	if current == "" {
+		return "", fmt.Errorf("%s has no releases to roll back to", s.Branch)
+	}
+
+	target := -1
+	for i, sha := range releases {
+		if release == "" && sha == current && i+1 < len(releases) {
+			target = i + 1
+		}
+		if release != "" && strings.HasPrefix(sha, release) {
+			if target >= 0 {
+				return "", fmt.Errorf("%s matches both %s and %s, use a longer SHA", release, shortSha(releases[target]), shortSha(sha))
+			}
+			target = i
+		}
+	}
+	if target < 0 && release == "" {
+		return "", fmt.Errorf("%s has no release before %s", s.Branch, shortSha(current))
+	}
+	if target < 0 {
+		return "", fmt.Errorf("%s has no release %s", s.Branch, release)
+	}
+	sha := releases[target]
+	if sha == current {
+		return "", fmt.Errorf("release %s is already live", shortSha(sha))
+	}
+
+	if restoreDatabase {
+		if target == 0 {
+			return "", fmt.Errorf("release %s is the newest, there is no database snapshot to restore", shortSha(sha))
+		}
+		s.UpdateProgress("Restoring database from before "+shortSha(releases[target-1]), 30)
+		s.release = s.releaseFolder(sha)
+		err := s.restoreDatabase(s.snapshotFile(releases[target-1]))
+		s.release = ""
+		if err != nil {
+			return "", err
+		}
+	}
+
+	s.UpdateProgress("Switching to release "+shortSha(sha), 80)
+	return sha, s.link(sha)
+}
+
+// discardRelease removes a release that was not activated.
+func (s *Server) discardRelease() {
+	if s.release == "" {
+		return
+	}
+	if err := os.RemoveAll(s.release); err != nil {
+		s.logger().Warn("Could not remove release", "release", s.release, "error", err)
+	}
+	s.release = ""
+}
+
+// Releases returns the SHAs of the releases on the server, the most recently
+// activated first. Releases built before the order was recorded come last,
+// the most recently modified first.
+func (s *Server) Releases() ([]string, error) {
+	files, err := ioutil.ReadDir(s.releasesFolder())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	folders := map[string]bool{}
+	var unordered []os.FileInfo
+	for _, file := range files {
+		if file.IsDir() && file.Name() != newRelease {
+			folders[file.Name()] = true
+			unordered = append(unordered, file)
+		}
+	}
+
+	var shas []string
+	order, err := ioutil.ReadFile(s.releasesFile())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, sha := range strings.Fields(string(order)) {
+		if folders[sha] {
+			shas = append(shas, sha)
+			delete(folders, sha)
+		}
+	}
+
+	sort.Slice(unordered, func(i, j int) bool { return unordered[i].ModTime().After(unordered[j].ModTime()) })
+	for _, release := range unordered {
+		if folders[release.Name()] {
+			shas = append(shas, release.Name())
+		}
+	}
+	return shas, nil
+}
+
+// writeReleases atomically replaces the recorded order of releases.
+func (s *Server) writeReleases(releases []string) error {
+	tmp := s.releasesFile() + newRelease
+	if err := ioutil.WriteFile(tmp, []byte(strings.Join(releases, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.releasesFile())
+}
+
+func (s *Server) pruneReleases() error {
+	releases, err := s.Releases()
+	if err != nil {
+		return err
+	}
+
+	keep := s.KeepReleases
+	if keep < 1 {
+		keep = 1
+	}
+	current := s.currentRelease()
+	var kept []string
+	for i, sha := range releases {
+		if i < keep || sha == current {
+			kept = append(kept, sha)
+			continue
+		}
+		if err := os.RemoveAll(s.releaseFolder(sha)); err != nil {
+			return err
+		}
+	}
+	return s.writeReleases(kept)
+}
+
+func (s *Server) snapshotFile(sha string) string {
+	return s.releaseFolder(sha) + "/.staging-database.sql"
+}
+
+// snapshotDatabase dumps the database before the release that is being built
+// migrates it.
+func (s *Server) snapshotDatabase() error {
+	return s.runCommand(fmt.Sprintf("mysqldump --single-transaction %s > %s", s.safeBranch(), s.snapshotFile(path.Base(s.release))))
+}
+
+func (s *Server) restoreDatabase(snapshot string) error {
+	if _, err := os.Stat(snapshot); err != nil {
+		return fmt.Errorf("no database snapshot: %s", err)
+	}
+	if err := s.dropDatabase(); err != nil {
+		return err
+	}
+	return s.runCommands([]string{
+		"php bin/console doctrine:database:create",
+		fmt.Sprintf("mysql %s < %s", s.safeBranch(), snapshot),
+	})
+}
@@ -0,0 +1,147 @@
+package staging
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestActivateSwapsCurrentAndPrunesReleases(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "releases")
+	defer os.RemoveAll(dir)
+
+	s := NewServer("feature", nil)
+	s.RootFolder = dir
+	s.KeepReleases = 2
+
+	for _, sha := range []string{"aaa", "bbb", "ccc"} {
+		writeFile(t, filepath.Join(s.releaseFolder(sha), "web", "index.html"), sha)
+		s.release = s.releaseFolder(sha)
+		if err := s.activate(); err != nil {
+			t.Fatal(err)
+		}
+
+		content, err := ioutil.ReadFile(filepath.Join(s.currentFolder(), "web", "index.html"))
+		if err != nil || string(content) != sha {
+			t.Errorf("Expected current to serve %s, got %q, %v", sha, content, err)
+		}
+	}
+
+	if current := s.currentRelease(); current != "ccc" {
+		t.Errorf("Expected current release ccc, got %s", current)
+	}
+	releases, _ := s.Releases()
+	if len(releases) != 2 || releases[0] != "ccc" || releases[1] != "bbb" {
+		t.Errorf("Expected releases [ccc bbb], got %v", releases)
+	}
+	if s.workDir() != s.currentLink() {
+		t.Errorf("Expected commands to run in the current release, got %s", s.workDir())
+	}
+}
+
+func TestCurrentFolderOfServerWithoutReleases(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "releases")
+	defer os.RemoveAll(dir)
+
+	s := NewServer("feature", nil)
+	s.RootFolder = dir
+	os.Mkdir(s.folder(), 0755)
+
+	if s.currentFolder() != s.folder() {
+		t.Errorf("Expected the server folder, got %s", s.currentFolder())
+	}
+}
+
+func TestRollback(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "releases")
+	defer os.RemoveAll(dir)
+
+	s := NewServer("feature", func(message string, progress int) {})
+	s.RootFolder = dir
+	for _, sha := range []string{"aaa111", "bbb222", "ccc333"} {
+		os.MkdirAll(s.releaseFolder(sha), 0755)
+		s.release = s.releaseFolder(sha)
+		if err := s.activate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		release  string
+		expected string
+	}{
+		{"", "bbb222"},
+		{"", "aaa111"},
+		{"ccc", "ccc333"},
+	} {
+		sha, err := s.Rollback(test.release, false)
+		if err != nil || sha != test.expected || s.currentRelease() != test.expected {
+			t.Errorf("Expected rollback to %q to switch to %s, got %s, %v (current %s)", test.release, test.expected, sha, err, s.currentRelease())
+		}
+	}
+
+	if _, err := s.Rollback("ccc", false); err == nil {
+		t.Error("Expected an error when rolling back to the live release")
+	}
+	if _, err := s.Rollback("ddd", false); err == nil {
+		t.Error("Expected an error for an unknown release")
+	}
+	if _, err := s.Rollback("aaa", true); err == nil {
+		t.Error("Expected an error without a database snapshot")
+	}
+}
+
+func TestReleasesFollowActivationOrder(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "releases")
+	defer os.RemoveAll(dir)
+
+	s := NewServer("feature", func(message string, progress int) {})
+	s.RootFolder = dir
+	for _, sha := range []string{"aaa111", "bbb222", "ccc333"} {
+		os.MkdirAll(s.releaseFolder(sha), 0755)
+		s.release = s.releaseFolder(sha)
+		if err := s.activate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Copying or restoring the server folder changes the modification times.
+	for i, sha := range []string{"aaa111", "bbb222", "ccc333"} {
+		touched := time.Now().Add(time.Duration(-i) * time.Minute)
+		os.Chtimes(s.releaseFolder(sha), touched, touched)
+	}
+
+	releases, _ := s.Releases()
+	if len(releases) != 3 || releases[0] != "ccc333" || releases[1] != "bbb222" || releases[2] != "aaa111" {
+		t.Errorf("Expected releases [ccc333 bbb222 aaa111], got %v", releases)
+	}
+	if sha, err := s.Rollback("", false); err != nil || sha != "bbb222" {
+		t.Errorf("Expected rollback to bbb222, got %s, %v", sha, err)
+	}
+}
+
+func TestRollbackRejectsAmbiguousReleases(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "releases")
+	defer os.RemoveAll(dir)
+
+	s := NewServer("feature", func(message string, progress int) {})
+	s.RootFolder = dir
+	for _, sha := range []string{"abc111", "abc222", "def333"} {
+		os.MkdirAll(s.releaseFolder(sha), 0755)
+		s.release = s.releaseFolder(sha)
+		if err := s.activate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := s.Rollback("abc", false); err == nil {
+		t.Error("Expected an error for a prefix of two releases")
+	}
+	if s.currentRelease() != "def333" {
+		t.Errorf("Expected def333 to stay live, got %s", s.currentRelease())
+	}
+	if sha, err := s.Rollback("abc1", false); err != nil || sha != "abc111" {
+		t.Errorf("Expected rollback to abc111, got %s, %v", sha, err)
+	}
+}
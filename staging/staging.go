@@ -1,14 +1,29 @@
 package staging
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
 	"os"
 	"os/exec"
+	"path"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/vektorprogrammet/build-system/git"
+	"github.com/vektorprogrammet/build-system/health"
+	"github.com/vektorprogrammet/build-system/metrics"
 	"github.com/vektorprogrammet/build-system/nginx"
+	"github.com/vektorprogrammet/build-system/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Server struct {
@@ -17,6 +32,27 @@ type Server struct {
 	RootFolder     string
 	Domain         string
 	UpdateProgress func(message string, progress int)
+	Log            io.Writer
+	// Ref is fetched instead of the branch when set, like refs/pull/42/head
+	// for pull requests from forks.
+	Ref string
+	// Commit is the SHA to deploy. The server follows the head of the branch
+	// when it is empty.
+	Commit string
+	// MirrorFolder holds bare mirrors that servers are cloned from. Servers
+	// are cloned straight from Repo when it is empty.
+	MirrorFolder string
+	// CacheFolder holds installed dependencies shared between servers.
+	// Dependencies are installed from scratch when it is empty.
+	CacheFolder string
+	// KeepReleases is the number of releases kept for rollbacks.
+	KeepReleases int
+	// Logger defaults to slog.Default.
+	Logger *slog.Logger
+	// Context carries the span that steps are traced as children of.
+	Context context.Context
+
+	release string
 }
 
 const DefaultRepo = "https://github.com/vektorprogrammet/vektorprogrammet"
@@ -27,12 +63,19 @@ const DefaultDomain = "staging.vektorprogrammet.no"
 
 const DefaultInstallationFolder = "/var/www/staging-server"
 
+const DefaultLogFolder = "/var/www/staging-logs"
+
+const DefaultMirrorFolder = DefaultInstallationFolder + "/mirrors"
+
 func NewServer(branch string, updateProgress func(message string, progress int)) Server {
 	s := Server{}
 	// Default values
 	s.Repo = DefaultRepo
 	s.RootFolder = DefaultRootFolder
 	s.Domain = DefaultDomain
+	s.MirrorFolder = DefaultMirrorFolder
+	s.CacheFolder = DefaultCacheFolder
+	s.KeepReleases = DefaultKeepReleases
 
 	// Initialize fields
 	s.Branch = branch
@@ -43,15 +86,30 @@ func NewServer(branch string, updateProgress func(message string, progress int))
 
 func (s *Server) MarshalJSON() ([]byte, error) {
 	var tmp struct {
-		Repo   string `json:"repo"`
-		Branch string `json:"branch"`
-		Domain string `json:"domain"`
-		Url    string `json:"url"`
+		Repo      string         `json:"repo"`
+		Branch    string         `json:"branch"`
+		Domain    string         `json:"domain"`
+		Url       string         `json:"url"`
+		Sha       string         `json:"sha"`
+		Pinned    bool           `json:"pinned"`
+		Untrusted bool           `json:"untrusted"`
+		Releases  []string       `json:"releases"`
+		Health    *health.Report `json:"health"`
+		Uptime    *float64       `json:"uptime"`
 	}
 	tmp.Repo = s.Repo
 	tmp.Branch = s.Branch
 	tmp.Domain = s.Domain
 	tmp.Url = "https://" + s.ServerName()
+	tmp.Sha = s.CurrentSha()
+	tmp.Pinned = s.IsPinned()
+	tmp.Untrusted = s.IsUntrusted()
+	tmp.Releases, _ = s.Releases()
+	tmp.Health, _ = health.LoadReport(s.HealthFile())
+	if history, _ := health.LoadHistory(s.HealthHistoryFile()); len(history) > 0 {
+		uptime := health.Uptime(history)
+		tmp.Uptime = &uptime
+	}
 
 	return json.Marshal(&tmp)
 }
@@ -63,11 +121,12 @@ func (s *Server) Deploy() error {
 	}
 
 	s.UpdateProgress("Cloning repository", 10)
-	if err := s.clone(); err != nil {
-		return err
-	}
-
-	if err := s.checkout(); err != nil {
+	if err := s.step("clone", func() error {
+		if err := s.updateMirror(); err != nil {
+			return err
+		}
+		return s.buildRelease(s.Commit)
+	}); err != nil {
 		return err
 	}
 
@@ -80,12 +139,12 @@ func (s *Server) Deploy() error {
 	}
 
 	s.UpdateProgress("Installing composer and NPM dependencies", 30)
-	if err := s.install(); err != nil {
+	if err := s.step("install", s.install); err != nil {
 		return err
 	}
 
 	s.UpdateProgress("Creating database", 70)
-	if err := s.createDatabase(); err != nil {
+	if err := s.step("database", s.createDatabase); err != nil {
 		return err
 	}
 
@@ -97,45 +156,60 @@ func (s *Server) Deploy() error {
 		return err
 	}
 
+	if err := s.activate(); err != nil {
+		return err
+	}
+
 	s.UpdateProgress("Creating nginx instance", 85)
-	if err := s.createNginxConfig(); err != nil {
+	if err := s.step("nginx", s.createNginxConfig); err != nil {
 		return err
 	}
 
 	s.UpdateProgress("Creating HTTPS certificate", 90)
-	if err := s.secureWithHttps(); err != nil {
+	if err := s.step("certs", s.secureWithHttps); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (s *Server) CanBeFastForwarded() bool {
-	c := exec.Command("sh", "-c", "git remote update")
-	c.Dir = s.folder()
-	_, err := c.Output()
-	if err != nil {
-		fmt.Printf("Could not update remotes: %s\n", err)
-		return false
+// Changes compares the deployed commit with the head of the branch, or with
+// Commit if it is set.
+func (s *Server) Changes() (git.Comparison, error) {
+	if err := s.updateMirror(); err != nil {
+		return git.Comparison{}, err
 	}
 
-	c = exec.Command("sh", "-c", "git status")
-	c.Dir = s.folder()
-	output, err := c.Output()
-	if err != nil {
-		fmt.Printf("Could not execute git status: %s\n", err)
-		return false
+	ref := s.CurrentRef()
+	if ref == "" {
+		ref = "refs/heads/" + s.Branch
 	}
-	return strings.Contains(string(output), "can be fast-forwarded") ||
-		strings.Contains(string(output), "have diverged")
+
+	return git.Repo{Dir: s.currentFolder()}.CompareRemote("origin", ref, s.Commit)
 }
 
-func (s *Server) Update() error {
-	if err := s.runCommand(fmt.Sprintf("git reset --hard origin/%s", s.Branch)); err != nil {
+// Update builds the target of a comparison from Changes as a new release and
+// switches to it once it is installed and migrated. The live release is left
+// alone if anything fails.
+func (s *Server) Update(c git.Comparison) error {
+	switch c.Status {
+	case git.UpToDate:
+		return nil
+	case git.MissingRemote:
+		return fmt.Errorf("%s does not exist on origin", s.Branch)
+	case git.FastForward:
+		s.UpdateProgress(fmt.Sprintf("Building release %s", shortSha(c.Target)), 10)
+	default:
+		s.UpdateProgress(fmt.Sprintf("History is %s, building release %s", c.Status, shortSha(c.Target)), 10)
+	}
+
+	current := s.currentFolder()
+	defer s.discardRelease()
+	if err := s.step("clone", func() error { return s.buildRelease(c.Target) }); err != nil {
 		return err
 	}
 
-	if err := s.runCommand(fmt.Sprintf("git pull origin %s", s.Branch)); err != nil {
+	if err := s.runCommand(fmt.Sprintf("cp %s/app/config/parameters.yml app/config/parameters.yml", current)); err != nil {
 		return err
 	}
 
@@ -143,11 +217,146 @@ func (s *Server) Update() error {
 		return err
 	}
 
-	if err := s.install(); err != nil {
+	s.UpdateProgress("Installing composer and NPM dependencies", 30)
+	if err := s.step("install", s.install); err != nil {
+		return err
+	}
+
+	s.UpdateProgress("Migrating database", 70)
+	if err := s.snapshotDatabase(); err != nil {
+		s.logMessage(slog.LevelWarn, fmt.Sprintf("Could not take a database snapshot: %s", err))
+	}
+	if err := s.step("database", s.updateDatabase); err != nil {
+		return err
+	}
+
+	if err := s.setFolderPermissions(); err != nil {
+		return err
+	}
+
+	s.UpdateProgress("Switching to release "+shortSha(c.Target), 90)
+	if err := s.activate(); err != nil {
 		return err
 	}
+	if current == s.folder() {
+		return s.useCurrentRelease()
+	}
+	return nil
+}
+
+var stepDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "staging_step_duration_seconds",
+	Help:    "Duration of deploy and update steps.",
+	Buckets: metrics.DefaultBuckets,
+}, []string{"step"})
+
+// step runs a step of a deploy or update in its own span and records how
+// long it took. The step is added to everything that is logged while it runs.
+func (s *Server) step(name string, run func() error) error {
+	logger, ctx := s.Logger, s.Context
+	s.Logger = s.logger().With("step", name)
+	var span trace.Span
+	s.Context, span = tracing.Start(ctx, name, trace.WithAttributes(attribute.String("step", name)))
+	defer func() { s.Logger, s.Context = logger, ctx }()
+
+	start := time.Now()
+	err := run()
+	tracing.End(span, err)
+	stepDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	return err
+}
+
+func shortSha(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+func (s *Server) ResetDatabase() error {
+	s.UpdateProgress("Dropping database", 0)
+	if err := s.dropDatabase(); err != nil {
+		return err
+	}
+
+	if err := s.createSetupParametersFile(); err != nil {
+		return err
+	}
+
+	s.UpdateProgress("Creating database", 30)
+	if err := s.createDatabase(); err != nil {
+		return err
+	}
+
+	return s.createParametersFile()
+}
+
+func (s *Server) Pin() error {
+	return ioutil.WriteFile(s.pinFile(), []byte{}, 0644)
+}
+
+func (s *Server) Unpin() error {
+	err := os.Remove(s.pinFile())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *Server) IsPinned() bool {
+	_, err := os.Stat(s.pinFile())
+
+	return err == nil
+}
+
+func (s *Server) pinFile() string {
+	return s.folder() + "/.staging-pinned"
+}
+
+// CurrentSha is the commit that is checked out on the server.
+func (s *Server) CurrentSha() string {
+	sha, err := git.Repo{Dir: s.currentFolder()}.Head()
+	if err != nil {
+		return ""
+	}
+	return sha
+}
+
+// IsUntrusted is true for servers of pull requests from forks. They do not
+// get the secrets in parameters.yml.
+func (s *Server) IsUntrusted() bool {
+	return strings.HasPrefix(s.CurrentRef(), "refs/pull/")
+}
+
+// CurrentRef is the ref the server is deployed from, or empty if it follows its
+// branch.
+func (s *Server) CurrentRef() string {
+	if s.Ref != "" {
+		return s.Ref
+	}
+	ref, err := ioutil.ReadFile(s.refFile())
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(ref))
+}
+
+func (s *Server) refFile() string {
+	return s.folder() + "/.staging-ref"
+}
+
+// HealthFile holds the report of the latest health check.
+func (s *Server) HealthFile() string {
+	return s.folder() + "/.staging-health.json"
+}
+
+// HealthHistoryFile holds the reports of the uptime monitor.
+func (s *Server) HealthHistoryFile() string {
+	return s.folder() + "/.staging-health-history.json"
+}
 
-	return s.updateDatabase()
+func (s *Server) LogFile() string {
+	return DefaultLogFolder + "/" + s.safeBranch() + ".log"
 }
 
 func (s *Server) Exists() bool {
@@ -157,24 +366,63 @@ func (s *Server) Exists() bool {
 }
 
 func (s *Server) createServerFolder() error {
-	if _, err := os.Stat(s.folder()); os.IsNotExist(err) {
-		os.Mkdir(s.folder(), 0755)
+	return os.MkdirAll(s.releasesFolder(), 0755)
+}
+
+func (s *Server) clone() error {
+	if mirror := s.mirror(); mirror != nil {
+		return s.runGit("clone", mirror.Path, ".")
 	}
+	return s.runGit("clone", s.Repo, ".")
+}
 
-	return nil
+func (s *Server) mirror() *git.Mirror {
+	if s.MirrorFolder == "" {
+		return nil
+	}
+	return &git.Mirror{
+		Path: s.MirrorFolder + "/" + path.Base(s.Repo) + ".git",
+		Url:  s.Repo,
+	}
 }
 
-func (s *Server) clone() error {
-	return s.runCommand(fmt.Sprintf("git clone %s .", s.Repo))
+func (s *Server) updateMirror() error {
+	mirror := s.mirror()
+	if mirror == nil {
+		return nil
+	}
+	if err := mirror.Update(); err != nil {
+		return fmt.Errorf("could not update mirror of %s: %s", s.Repo, err)
+	}
+	return nil
 }
 
-func (s *Server) checkout() error {
-	return s.runCommand(fmt.Sprintf("git checkout %s", s.Branch))
+func (s *Server) checkout(commit string) error {
+	if s.Ref == "" {
+		if err := s.runGit("checkout", s.Branch, "--"); err != nil {
+			return err
+		}
+	} else {
+		if err := s.runGit("fetch", "origin", s.Ref); err != nil {
+			return err
+		}
+		if err := s.runGit("checkout", "-B", s.safeBranch(), "FETCH_HEAD"); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(s.refFile(), []byte(s.Ref+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+
+	if commit != "" {
+		return s.runGit("reset", "--hard", commit, "--")
+	}
+	return nil
 }
 
 func (s *Server) setFolderPermissions() error {
-	varDir := s.folder() + "/var"
-	webDir := s.folder() + "/web"
+	varDir := s.workDir() + "/var"
+	webDir := s.workDir() + "/web"
 
 	return s.runCommands([]string{
 		"setfacl -R -m u:vektorprogrammet:rwX .",
@@ -186,7 +434,7 @@ func (s *Server) setFolderPermissions() error {
 
 func (s *Server) createNginxConfig() error {
 	nginxConfig := nginx.Config{
-		Root:       s.folder() + "/web",
+		Root:       s.currentLink() + "/web",
 		ServerName: s.ServerName(),
 	}
 
@@ -195,7 +443,17 @@ func (s *Server) createNginxConfig() error {
 
 func (s *Server) createRobotsTxt() error {
 	robotContent := "User-agent: *\nDisallow: /"
-	return s.runCommand(fmt.Sprintf("echo '%s' > %s/web/robots.txt", robotContent, s.folder()))
+	return s.runCommand(fmt.Sprintf("echo '%s' > %s/web/robots.txt", robotContent, s.workDir()))
+}
+
+// useCurrentRelease points nginx to the current symlink for servers that were
+// deployed before releases were introduced.
+func (s *Server) useCurrentRelease() error {
+	config := "/srv/nginx/" + s.ServerName()
+	if err := s.runCommand(fmt.Sprintf("sed -i 's|root %s/web;|root %s/web;|' %s", s.folder(), s.currentLink(), config)); err != nil {
+		return err
+	}
+	return s.restartNginx()
 }
 
 func (s *Server) restartNginx() error {
@@ -222,32 +480,79 @@ func (s *Server) ServerName() string {
 }
 
 func (s *Server) install() error {
-	var wg sync.WaitGroup
-	wg.Add(3)
-
-	go func(wg *sync.WaitGroup) {
-		defer wg.Done()
-		s.runCommand("php ./composer.phar install -n --no-dev --optimize-autoloader")
-	}(&wg)
-
-	go func(wg *sync.WaitGroup) {
-		defer wg.Done()
-		s.runCommands([]string{
-			"npm install",
-			"npm run build:prod",
-		})
-	}(&wg)
-
-	go func(wg *sync.WaitGroup) {
-		defer wg.Done()
-		s.runCommands([]string{
-			"NODE_ENV=staging npm run setup:client",
-			"NODE_ENV=staging npm run build:client",
-		})
-	}(&wg)
+	installs := []func() error{
+		func() error {
+			return s.installCached("vendor", "php ./composer.phar install -n --no-dev --optimize-autoloader", "composer.json", "composer.lock")
+		},
+		func() error {
+			if err := s.installCached("node_modules", "npm install", "package.json", "package-lock.json"); err != nil {
+				return err
+			}
+			return s.runCommand("npm run build:prod")
+		},
+		func() error {
+			if err := s.installCached("client/node_modules", "NODE_ENV=staging npm run setup:client", "client/package.json", "client/package-lock.json"); err != nil {
+				return err
+			}
+			return s.runCommand("NODE_ENV=staging npm run build:client")
+		},
+	}
 
+	errs := make([]error, len(installs))
+	var wg sync.WaitGroup
+	for i, install := range installs {
+		wg.Add(1)
+		go func(i int, install func() error) {
+			defer wg.Done()
+			errs[i] = install()
+		}(i, install)
+	}
 	wg.Wait()
 
+	return errors.Join(errs...)
+}
+
+// installCached copies folder from the dependency cache if it is missing and
+// the same manifest and lock file have been installed before. The install
+// command runs after a cache hit too: with the packages in place it downloads
+// nothing, but it still runs the install scripts, like the autoloader dump,
+// for the code of this server. The folder is cached after a successful install
+// that did not come from the cache, unless the server runs untrusted code that
+// could have changed it.
+func (s *Server) installCached(folder, cmd string, files ...string) error {
+	if s.CacheFolder == "" {
+		return s.runCommand(cmd)
+	}
+
+	cache := DependencyCache{Folder: s.CacheFolder, MaxSize: DefaultCacheSize, Logger: s.logger()}
+	var paths []string
+	for _, file := range files {
+		paths = append(paths, path.Join(s.workDir(), file))
+	}
+	key := cache.Key(strings.Replace(folder, "/", "-", -1), paths...)
+	target := path.Join(s.workDir(), folder)
+
+	hit := false
+	if _, err := os.Stat(target); os.IsNotExist(err) {
+		hit, err = cache.Restore(key, target)
+		if err != nil {
+			s.logMessage(slog.LevelWarn, fmt.Sprintf("Could not restore %s from the dependency cache: %s", folder, err))
+		}
+	}
+	if hit {
+		s.logMessage(slog.LevelInfo, fmt.Sprintf("Dependency cache hit: %s (%s)", folder, key))
+	} else {
+		s.logMessage(slog.LevelInfo, fmt.Sprintf("Dependency cache miss: %s (%s)", folder, strings.Join(files, ", ")))
+	}
+
+	if err := s.runCommand(cmd); err != nil {
+		return err
+	}
+	if !hit && !s.IsUntrusted() {
+		if err := cache.Store(key, target); err != nil {
+			s.logMessage(slog.LevelWarn, fmt.Sprintf("Could not store %s in the dependency cache: %s", folder, err))
+		}
+	}
 	return nil
 }
 
@@ -277,7 +582,12 @@ func (s *Server) createSetupParametersFile() error {
 }
 
 func (s *Server) createParametersFile() error {
-	cmd := fmt.Sprintf("cp %s/parameters.yml app/config/parameters.yml", DefaultInstallationFolder)
+	parameters := "parameters.yml"
+	if s.IsUntrusted() {
+		parameters = "parameters_untrusted.yml"
+	}
+
+	cmd := fmt.Sprintf("cp %s/%s app/config/parameters.yml", DefaultInstallationFolder, parameters)
 	if err := s.runCommand(cmd); err != nil {
 		return err
 	}
@@ -311,22 +621,107 @@ func (s *Server) Remove() error {
 }
 
 func (s *Server) runCommand(cmd string) error {
-	fmt.Println("Executing " + cmd)
-	c := exec.Command("sh", "-c", cmd)
-	c.Dir = s.folder()
+	return s.execute(cmd, exec.Command("sh", "-c", cmd))
+}
+
+// runGit runs git without a shell, since branches, refs and commits come from
+// webhooks and API requests.
+func (s *Server) runGit(args ...string) error {
+	return s.execute("git "+strings.Join(args, " "), exec.Command("git", args...))
+}
+
+func (s *Server) execute(cmd string, c *exec.Cmd) error {
+	logger := s.logger().With("command", cmd, "dir", s.workDir())
+	logger.Debug("Executing command")
+	start := time.Now()
+	c.Dir = s.workDir()
+	c.Env = s.environ()
 	output, err := c.Output()
+	s.log(cmd, output, err)
+	s.traceCommand(cmd, start, err)
 	if err != nil {
-		fmt.Println(fmt.Sprintf("Error: %s", err))
+		logger.Error("Command failed", "error", err, "output", string(output))
 		return err
 	}
 
-	fmt.Println(s.folder())
-	fmt.Println(cmd)
-	fmt.Println(fmt.Sprintf("%s", output))
+	logger.Debug("Command finished", "duration_ms", time.Since(start).Milliseconds(), "output", string(output))
 
 	return nil
 }
 
+// traceCommand adds a command as an event to the span of the current step.
+func (s *Server) traceCommand(cmd string, start time.Time, err error) {
+	attributes := []attribute.KeyValue{
+		attribute.String("command", cmd),
+		attribute.String("dir", s.workDir()),
+		attribute.Int64("duration_ms", time.Since(start).Milliseconds()),
+	}
+	if err != nil {
+		attributes = append(attributes, attribute.String("error", err.Error()))
+	}
+	trace.SpanFromContext(s.Context).AddEvent("command", trace.WithTimestamp(start), trace.WithAttributes(attributes...))
+}
+
+func (s *Server) logger() *slog.Logger {
+	if s.Logger == nil {
+		return slog.Default().With("branch", s.Branch)
+	}
+	return s.Logger
+}
+
+// untrustedEnv lists the variables of the daemon that commands of untrusted
+// servers inherit. Everything else, like tokens, stays out of reach of code
+// from forks.
+var untrustedEnv = []string{"PATH", "HOME", "LANG", "TMPDIR"}
+
+// environ is the environment of the commands of the server.
+func (s *Server) environ() []string {
+	if !s.IsUntrusted() {
+		return append(os.Environ(), s.env()...)
+	}
+	var env []string
+	for _, name := range untrustedEnv {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return append(env, s.env()...)
+}
+
+// env points composer and npm to a package cache shared by all servers.
+func (s *Server) env() []string {
+	if s.CacheFolder == "" {
+		return nil
+	}
+	packages := path.Join(s.CacheFolder, "packages")
+	return []string{
+		"COMPOSER_CACHE_DIR=" + path.Join(packages, "composer"),
+		"npm_config_cache=" + path.Join(packages, "npm"),
+	}
+}
+
+func (s *Server) logMessage(level slog.Level, message string) {
+	s.logger().Log(context.Background(), level, message)
+	if s.Log != nil {
+		io.WriteString(s.Log, "# "+message+"\n")
+	}
+}
+
+func (s *Server) log(cmd string, output []byte, err error) {
+	if s.Log == nil {
+		return
+	}
+
+	entry := fmt.Sprintf("$ %s\n%s", cmd, output)
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		entry += string(exitErr.Stderr)
+	}
+	if err != nil {
+		entry += fmt.Sprintf("Error: %s\n", err)
+	}
+	io.WriteString(s.Log, entry)
+}
+
 func (s *Server) runCommands(cmds []string) error {
 	for _, cmd := range cmds {
 		if err := s.runCommand(cmd); err != nil {
@@ -0,0 +1,68 @@
+package staging
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/vektorprogrammet/build-system/tracing/tracingtest"
+	"go.opentelemetry.io/otel/codes"
+)
+
+func TestStepTracesCommands(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "staging")
+	defer os.RemoveAll(dir)
+	exporter, stop := tracingtest.Record()
+	defer stop()
+
+	s := NewServer("feature", nil)
+	s.release = dir
+	s.step("install", func() error { return s.runCommands([]string{"true", "echo installed"}) })
+	s.step("database", func() error { return s.runCommand("false") })
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 || spans[0].Name != "install" || spans[1].Name != "database" {
+		t.Fatalf("Expected install and database spans, got %v", spans.Snapshots())
+	}
+	install := spans[0]
+	if install.Status.Code == codes.Error || len(install.Events) != 2 {
+		t.Errorf("Expected install to succeed with 2 commands, got %v and %v", install.Status, install.Events)
+	}
+	for i, expected := range []string{"true", "echo installed"} {
+		if i < len(install.Events) && install.Events[i].Attributes[0].Value.AsString() != expected {
+			t.Errorf("Expected command %q, got %v", expected, install.Events[i].Attributes)
+		}
+	}
+	if spans[1].Status.Code != codes.Error {
+		t.Errorf("Expected database to fail, got %v", spans[1].Status)
+	}
+	if s.Context != nil {
+		t.Errorf("Expected the context to be restored after the step")
+	}
+}
+
+func TestUntrustedCommandsDoNotGetSecrets(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "staging")
+	defer os.RemoveAll(dir)
+	t.Setenv("GITHUB_ACCESS_TOKEN", "daemon-secret")
+
+	for ref, expectSecret := range map[string]bool{"": true, "refs/pull/42/head": false} {
+		var log bytes.Buffer
+		s := NewServer("feature", nil)
+		s.Ref = ref
+		s.Log = &log
+		s.release = dir
+		if err := s.runCommand("env"); err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.Contains(log.String(), "daemon-secret") != expectSecret {
+			t.Errorf("Expected secret in environment of ref %q to be %v, got %s", ref, expectSecret, log.String())
+		}
+		if !strings.Contains(log.String(), "PATH=") {
+			t.Errorf("Expected PATH in environment of ref %q, got %s", ref, log.String())
+		}
+	}
+}
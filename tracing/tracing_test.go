@@ -0,0 +1,55 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/vektorprogrammet/build-system/tracing/tracingtest"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestEnd(t *testing.T) {
+	exporter, stop := tracingtest.Record()
+	defer stop()
+
+	_, ok := Start(context.Background(), "ok")
+	End(ok, nil)
+	_, failed := Start(context.Background(), "failed")
+	End(failed, errors.New("exit status 1"))
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	if spans[0].Status.Code != codes.Unset {
+		t.Errorf("Expected ok to have no status, got %v", spans[0].Status)
+	}
+	if spans[1].Status.Code != codes.Error || spans[1].Status.Description != "exit status 1" {
+		t.Errorf("Expected failed to have an error status, got %v", spans[1].Status)
+	}
+	if len(spans[1].Events) != 1 || spans[1].Events[0].Name != "exception" {
+		t.Errorf("Expected the error to be recorded, got %v", spans[1].Events)
+	}
+}
+
+func TestContinue(t *testing.T) {
+	exporter, stop := tracingtest.Record()
+	defer stop()
+
+	_, parent := Start(context.Background(), "webhook")
+	parent.End()
+	_, child := Start(Continue(parent.SpanContext()), "deploy")
+	child.End()
+	_, root := Start(Continue(trace.SpanContext{}), "cli")
+	root.End()
+
+	spans := exporter.GetSpans()
+	if spans[1].Parent.SpanID() != spans[0].SpanContext.SpanID() || spans[1].SpanContext.TraceID() != spans[0].SpanContext.TraceID() {
+		t.Errorf("Expected deploy to continue the trace of the webhook")
+	}
+	if spans[2].Parent.IsValid() {
+		t.Errorf("Expected cli to start a new trace, got parent %s", spans[2].Parent.SpanID())
+	}
+}
@@ -0,0 +1,18 @@
+// Package tracingtest records spans in memory, for tests of code that is
+// traced with the tracing package.
+package tracingtest
+
+import (
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Record installs a tracer provider that keeps finished spans in memory.
+// Spans are dropped again after stop is called.
+func Record() (exporter *tracetest.InMemoryExporter, stop func()) {
+	exporter = tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	return exporter, func() { otel.SetTracerProvider(noop.NewTracerProvider()) }
+}